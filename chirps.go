@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+// presentChirps converts chirps for the API, filling in their counts and
+// embedding the chirps that rechirps and quotes refer to, one level deep.
+func (cfg *apiConfig) presentChirps(dbChirps []database.Chirp) ([]Chirp, error) {
+	originalIDs := []int{}
+	for _, dbChirp := range dbChirps {
+		if id := referencedChirpID(dbChirp); id != 0 {
+			originalIDs = append(originalIDs, id)
+		}
+	}
+	originals, err := cfg.DB.GetChirpsByID(originalIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := append([]int{}, originalIDs...)
+	for _, dbChirp := range dbChirps {
+		ids = append(ids, dbChirp.ID)
+	}
+	stats, err := cfg.DB.GetChirpStats(ids)
+	if err != nil {
+		return nil, err
+	}
+	withStats := func(dbChirp database.Chirp) Chirp {
+		chirp := chirpFromDB(dbChirp)
+		chirp.RechirpCount = stats[dbChirp.ID].Rechirps
+		chirp.QuoteCount = stats[dbChirp.ID].Quotes
+		chirp.LikeCount = stats[dbChirp.ID].Likes
+		return chirp
+	}
+
+	chirps := make([]Chirp, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirp := withStats(dbChirp)
+		if id := referencedChirpID(dbChirp); id != 0 {
+			original, ok := originals[id]
+			if ok && !original.Deleted() {
+				o := withStats(original)
+				chirp.Original = &o
+			} else {
+				chirp.OriginalDeleted = true
+			}
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, nil
+}
+
+func (cfg *apiConfig) presentChirp(dbChirp database.Chirp) (Chirp, error) {
+	chirps, err := cfg.presentChirps([]database.Chirp{dbChirp})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+// referencedChirpID returns the ID of the chirp a rechirp or quote refers
+// to, or 0 for any other chirp.
+func referencedChirpID(dbChirp database.Chirp) int {
+	if dbChirp.RechirpOf != 0 {
+		return dbChirp.RechirpOf
+	}
+	return dbChirp.QuoteOf
+}
+
+// presentedETag returns the ETag respondWithChirp sends for dbChirp, or ""
+// if presenting it fails.
+func (cfg *apiConfig) presentedETag(dbChirp database.Chirp) string {
+	chirp, err := cfg.presentChirp(dbChirp)
+	if err != nil {
+		return ""
+	}
+	return chirpETag(dbChirp.Version, chirp)
+}
+
+// respondWithChirps sends a chirp listing, or a 500 if presenting it fails.
+func (cfg *apiConfig) respondWithChirps(w http.ResponseWriter, dbChirps []database.Chirp) {
+	chirps, err := cfg.presentChirps(dbChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+// respondWithChirp sends a single chirp with its ETag, or a 500 if
+// presenting it fails.
+func (cfg *apiConfig) respondWithChirp(w http.ResponseWriter, code int, dbChirp database.Chirp) {
+	chirp, err := cfg.presentChirp(dbChirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
+	}
+	w.Header().Set("ETag", chirpETag(dbChirp.Version, chirp))
+	respondWithJSON(w, code, chirp)
+}
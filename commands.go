@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+// runCommand runs a subcommand. opts carries the settings commands need to
+// open the store, such as encryption keys.
+func runCommand(storeKind, dbPath string, opts database.Options, args []string) error {
+	switch args[0] {
+	case "import-json":
+		return commandImportJSON(storeKind, dbPath, opts, args[1:])
+	case "migrate":
+		return commandMigrate(storeKind, dbPath, opts, args[1:])
+	case "backup":
+		return commandBackup(storeKind, dbPath, opts, args[1:])
+	case "restore":
+		return commandRestore(storeKind, dbPath, opts, args[1:])
+	case "export":
+		return commandExport(storeKind, dbPath, opts, args[1:])
+	case "import":
+		return commandImport(storeKind, dbPath, opts, args[1:])
+	case "fsck":
+		return commandFsck(storeKind, dbPath, opts, args[1:])
+	case "rekey":
+		return commandRekey(storeKind, dbPath, opts, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// commandImportJSON loads an existing database.json into the SQLite store.
+func commandImportJSON(storeKind, dbPath string, opts database.Options, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: chirpy -store sqlite import-json <database.json>")
+	}
+	if storeKind != "sqlite" {
+		return errors.New("import-json requires -store sqlite")
+	}
+	db, err := database.NewSQLiteDB(storePath(storeKind, dbPath))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.ImportJSON(args[0], opts.Keys)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %s", args[0])
+	return nil
+}
+
+// commandMigrate upgrades the JSON database to the latest schema version,
+// or with -dry-run only prints what would change.
+func commandMigrate(storeKind, dbPath string, opts database.Options, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Report what would change without writing anything")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if storeKind != "json" {
+		return errors.New("migrate requires -store json; other stores migrate on startup")
+	}
+	path := storePath(storeKind, dbPath)
+
+	if !*dryRun {
+		db, err := database.NewDBWithOptions(path, opts)
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	}
+
+	report, err := database.PlanMigrations(path, opts.Keys)
+	if err != nil {
+		return err
+	}
+	if len(report) == 0 {
+		fmt.Printf("%s is up to date\n", path)
+		return nil
+	}
+	for _, line := range report {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// commandBackup writes a backup of the store to a file. With -server it
+// asks a running chirpy for the backup instead, so that it matches what the
+// server has in memory.
+func commandBackup(storeKind, dbPath string, opts database.Options, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	server := fs.String("server", "", "Base URL of a running chirpy to back up, e.g. http://localhost:8080")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: chirpy backup [-server URL] <path>")
+	}
+	path := fs.Arg(0)
+
+	if *server != "" {
+		err = fetchBackup(*server, path)
+	} else {
+		// Back up from a read-only view, so that this works while a
+		// server has the database open.
+		opts.ReadOnly = true
+		var db database.Store
+		db, err = openStore(storeKind, dbPath, opts)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		err = database.WriteBackupFile(path, db)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote backup to %s", path)
+	return nil
+}
+
+func fetchBackup(server, path string) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(server, "/")+"/admin/backup", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "ApiKey "+os.Getenv("ADMIN_API_KEY"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded with %s", resp.Status)
+	}
+
+	dat, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_, err = database.ReadBackup(bytes.NewReader(dat))
+	if err != nil {
+		return fmt.Errorf("server sent an invalid backup: %w", err)
+	}
+	return os.WriteFile(path, dat, 0600)
+}
+
+// commandRestore replaces the contents of the store with a backup. The
+// server must not be running against the same store.
+func commandRestore(storeKind, dbPath string, opts database.Options, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: chirpy restore <path>")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := database.ReadBackup(f)
+	if err != nil {
+		return fmt.Errorf("refusing to restore %s: %w", args[0], err)
+	}
+
+	db, err := openStore(storeKind, dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = db.Restore(data)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %s (%d users, %d chirps, %d likes, %d refresh tokens)",
+		args[0], len(data.Users), len(data.Chirps), len(data.Likes), len(data.RefreshTokens),
+	)
+	return nil
+}
+
+// commandExport writes the store as newline-delimited JSON to a file, or to
+// stdout if the path is "-" or missing.
+func commandExport(storeKind, dbPath string, opts database.Options, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	collections := fs.String("collections", "", "Comma-separated collections to export: users, chirps, likes, refresh_tokens")
+	exclude := fs.String("exclude", "", "Comma-separated data to leave out: password_hashes, refresh_tokens")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("usage: chirpy export [-collections LIST] [-exclude LIST] [path]")
+	}
+	exportOpts, err := exportOptions(*collections, *exclude)
+	if err != nil {
+		return err
+	}
+
+	opts.ReadOnly = true
+	db, err := openStore(storeKind, dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	out := os.Stdout
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	err = database.ExportNDJSON(w, db, exportOpts)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// commandImport adds the records in a newline-delimited JSON file to the
+// store. Nothing is imported if any line is invalid.
+func commandImport(storeKind, dbPath string, opts database.Options, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	remapIDs := fs.Bool("remap-ids", false, "Give imported users, chirps and likes fresh IDs")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: chirpy import [-remap-ids] <path>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	db, err := openStore(storeKind, dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := database.ImportNDJSON(f, db, database.ImportOptions{
+		RemapIDs: *remapIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't import %s:\n%w", fs.Arg(0), err)
+	}
+	log.Printf("Imported %d users, %d chirps, %d likes and %d refresh tokens", result.Users, result.Chirps, result.Likes, result.RefreshTokens)
+	return nil
+}
+
+// commandRekey re-encrypts the JSON database with the first configured key.
+// To rotate keys, list the new key first and keep the old one after it
+// until rekey has run.
+func commandRekey(storeKind, dbPath string, opts database.Options, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: chirpy rekey")
+	}
+	if storeKind != "json" {
+		return errors.New("rekey requires -store json")
+	}
+	if opts.Keys == nil {
+		return errors.New("rekey needs DB_ENCRYPTION_KEYS or -db-key-file")
+	}
+	db, err := database.NewDBWithOptions(storePath(storeKind, dbPath), opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Rekey()
+}
+
+// commandFsck checks the integrity of the store and prints what it found.
+// With -repair it also fixes what it can. It fails if any problems are
+// left.
+func commandFsck(storeKind, dbPath string, opts database.Options, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "Fix the problems that can be fixed automatically")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: chirpy fsck [-repair]")
+	}
+
+	// Checking alone works alongside a running server.
+	opts.ReadOnly = !*repair
+	db, err := openStore(storeKind, dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Fsck(*repair)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked %d users, %d chirps, %d likes and %d refresh tokens\n", report.Users, report.Chirps, report.Likes, report.RefreshTokens)
+	for _, p := range report.Problems {
+		status := ""
+		if p.Repaired {
+			status = " (repaired)"
+		}
+		fmt.Printf("%s/%s: %s%s\n", p.Collection, p.Key, p.Problem, status)
+	}
+	if n := report.Unrepaired(); n > 0 {
+		return fmt.Errorf("%d problems found", n)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag formats a record version as a strong entity tag.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// chirpETag tags a chirp as presented by the API. Its counts and the chirp
+// it embeds change without changing its version, so the version is
+// followed by a hash of the whole representation.
+func chirpETag(version int, chirp Chirp) string {
+	dat, err := json.Marshal(chirp)
+	if err != nil {
+		return etag(version)
+	}
+	h := fnv.New64a()
+	h.Write(dat)
+	return fmt.Sprintf(`"%d-%x"`, version, h.Sum64())
+}
+
+// etagMatches reports whether an If-None-Match header value matches tag.
+// The comparison is weak, so W/ prefixes are ignored.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchMatches reports whether an If-Match header value matches version.
+// Only strong tags are accepted. A write is conditional on the record
+// alone, so a tag from chirpETag matches on its version.
+func ifMatchMatches(header string, version int) bool {
+	want := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == want || strings.HasPrefix(tag, strings.TrimSuffix(want, `"`)+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns the version a write should be conditional on: zero
+// when the request has no If-Match header, otherwise current. ok is false
+// if the header doesn't match current, in which case a 412 has been sent
+// with the ETag currentTag returns, if it returns one.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current int, currentTag func() string) (version int, ok bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	if !ifMatchMatches(header, current) {
+		if tag := currentTag(); tag != "" {
+			w.Header().Set("ETag", tag)
+		}
+		respondWithError(w, http.StatusPreconditionFailed, "Resource has been modified")
+		return 0, false
+	}
+	return current, true
+}
@@ -8,3 +8,7 @@ require (
 )
 
 require github.com/joho/godotenv v1.5.1
+
+require github.com/mattn/go-sqlite3 v1.14.22
+
+require golang.org/x/text v0.14.0
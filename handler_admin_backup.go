@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+func (cfg *apiConfig) handlerAdminBackup(w http.ResponseWriter, r *http.Request) {
+	filename := fmt.Sprintf("chirpy-backup-%s.json", time.Now().UTC().Format("20060102T150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err := database.WriteBackup(w, cfg.DB)
+	if err != nil {
+		// The status line may already be out, so all we can do is log
+		// and cut the response short.
+		log.Printf("Couldn't write backup: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+}
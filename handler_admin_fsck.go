@@ -0,0 +1,16 @@
+package main
+
+import "net/http"
+
+// handlerAdminFsck checks the integrity of the store. A POST with
+// ?repair=true also fixes what can be fixed automatically.
+func (cfg *apiConfig) handlerAdminFsck(w http.ResponseWriter, r *http.Request) {
+	repair := r.Method == http.MethodPost && r.URL.Query().Get("repair") == "true"
+
+	report, err := cfg.DB.Fsck(repair)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check database")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+func (cfg *apiConfig) handlerAdminExport(w http.ResponseWriter, r *http.Request) {
+	opts, err := exportOptions(r.URL.Query().Get("collections"), r.URL.Query().Get("exclude"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	err = database.ExportNDJSON(w, cfg.DB, opts)
+	if err != nil {
+		log.Printf("Couldn't write export: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (cfg *apiConfig) handlerAdminImport(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Error  string                 `json:"error"`
+		Errors []database.ImportError `json:"errors"`
+	}
+
+	result, err := database.ImportNDJSON(r.Body, cfg.DB, database.ImportOptions{
+		RemapIDs: r.URL.Query().Get("remap_ids") == "true",
+	})
+	if err != nil {
+		var importErrs database.ImportErrors
+		if errors.As(err, &importErrs) {
+			respondWithJSON(w, http.StatusBadRequest, response{
+				Error:  "Import failed validation",
+				Errors: importErrs,
+			})
+			return
+		}
+		if errors.Is(err, database.ErrAlreadyExists) || errors.Is(err, database.ErrMissingReference) {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't import records")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// exportOptions parses the comma-separated collections and exclude lists
+// shared by the export endpoint and command.
+func exportOptions(collections, exclude string) (database.ExportOptions, error) {
+	opts := database.ExportOptions{}
+	for _, c := range splitList(collections) {
+		switch c {
+		case database.CollectionUsers, database.CollectionChirps, database.CollectionLikes, database.CollectionRefreshTokens:
+			opts.Collections = append(opts.Collections, c)
+		default:
+			return opts, errors.New("unknown collection " + c)
+		}
+	}
+	for _, e := range splitList(exclude) {
+		switch e {
+		case "password_hashes":
+			opts.ExcludePasswordHashes = true
+		case "refresh_tokens":
+			opts.ExcludeRefreshTokens = true
+		default:
+			return opts, errors.New("can't exclude " + e)
+		}
+	}
+	return opts, nil
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
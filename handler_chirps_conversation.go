@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+const (
+	defaultConversationDepth = 10
+	maxConversationDepth     = 50
+)
+
+// conversationNode is a chirp in a conversation and the replies to it. A
+// deleted chirp with replies is kept as a placeholder, with only its ID.
+type conversationNode struct {
+	ID      int                `json:"id"`
+	Chirp   *Chirp             `json:"chirp,omitempty"`
+	Deleted bool               `json:"deleted,omitempty"`
+	Replies []conversationNode `json:"replies"`
+	// MoreReplies counts the replies left out below the depth limit.
+	MoreReplies int `json:"more_replies,omitempty"`
+}
+
+// handlerChirpsConversation returns the whole thread a chirp belongs to as
+// a tree, starting from the chirp that began it. ?depth= limits how many
+// levels of replies are included, and replies to the same chirp are sorted
+// by ?sort= and ?sort_by=.
+func (cfg *apiConfig) handlerChirpsConversation(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+	maxDepth := defaultConversationDepth
+	if s := r.URL.Query().Get("depth"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxConversationDepth {
+			respondWithError(w, http.StatusBadRequest, "Invalid depth")
+			return
+		}
+		maxDepth = n
+	}
+	sortKey, sortOrder, ok := parseChirpSort(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, err := cfg.DB.GetConversation(chirpID, maxDepth)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+
+	dbChirps := conversation.Chirps
+	root := dbChirps[0]
+	replies := map[int][]database.Chirp{}
+	for _, dbChirp := range dbChirps[1:] {
+		replies[dbChirp.InReplyTo] = append(replies[dbChirp.InReplyTo], dbChirp)
+	}
+
+	// A deleted chirp is only worth showing if something below it isn't.
+	// Below the depth limit, that is judged from the count of replies left
+	// out.
+	visible := map[int]bool{}
+	var markVisible func(dbChirp database.Chirp) bool
+	markVisible = func(dbChirp database.Chirp) bool {
+		v := !dbChirp.Deleted() || conversation.MoreReplies[dbChirp.ID] > 0
+		for _, reply := range replies[dbChirp.ID] {
+			if markVisible(reply) {
+				v = true
+			}
+		}
+		visible[dbChirp.ID] = v
+		return v
+	}
+	if !markVisible(root) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+
+	shownChirps := []database.Chirp{}
+	for _, dbChirp := range dbChirps {
+		if !dbChirp.Deleted() && visible[dbChirp.ID] {
+			shownChirps = append(shownChirps, dbChirp)
+		}
+	}
+	presented, err := cfg.presentChirps(shownChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp")
+		return
+	}
+	chirps := map[int]Chirp{}
+	for _, chirp := range presented {
+		chirps[chirp.ID] = chirp
+	}
+
+	var build func(dbChirp database.Chirp, depth int) conversationNode
+	build = func(dbChirp database.Chirp, depth int) conversationNode {
+		node := conversationNode{ID: dbChirp.ID, Replies: []conversationNode{}}
+		if dbChirp.Deleted() {
+			node.Deleted = true
+		} else {
+			chirp := chirps[dbChirp.ID]
+			node.Chirp = &chirp
+		}
+
+		if depth == maxDepth {
+			node.MoreReplies = conversation.MoreReplies[dbChirp.ID]
+			return node
+		}
+		shown := []database.Chirp{}
+		for _, reply := range replies[dbChirp.ID] {
+			if visible[reply.ID] {
+				shown = append(shown, reply)
+			}
+		}
+		database.SortChirps(shown, sortKey, sortOrder)
+		for _, reply := range shown {
+			node.Replies = append(node.Replies, build(reply, depth+1))
+		}
+		return node
+	}
+
+	respondWithJSON(w, http.StatusOK, build(root, 0))
+}
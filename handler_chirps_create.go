@@ -6,19 +6,49 @@ import (
 	"net/http"
 	"strings"
 	"strconv"
+	"time"
 
 	"github.com/S0han/chirpy/webhooks/auth"
+	"github.com/S0han/chirpy/webhooks/database"
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
+	ID        int       `json:"id"`
+	AuthorID  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	InReplyTo int       `json:"in_reply_to,omitempty"`
+	RechirpOf int       `json:"rechirp_of,omitempty"`
+	QuoteOf   int       `json:"quote_of,omitempty"`
+	Hashtags  []string  `json:"hashtags,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Original is the chirp a rechirp shares or a quote quotes, filled in
+	// by presentChirps. If it has been deleted, only OriginalDeleted is set.
+	Original        *Chirp `json:"original,omitempty"`
+	OriginalDeleted bool   `json:"original_deleted,omitempty"`
+	RechirpCount    int    `json:"rechirp_count"`
+	QuoteCount      int    `json:"quote_count"`
+	LikeCount       int    `json:"like_count"`
+}
+
+func chirpFromDB(dbChirp database.Chirp) Chirp {
+	return Chirp{
+		ID:        dbChirp.ID,
+		AuthorID:  dbChirp.AuthorID,
+		Body:      dbChirp.Body,
+		InReplyTo: dbChirp.InReplyTo,
+		RechirpOf: dbChirp.RechirpOf,
+		QuoteOf:   dbChirp.QuoteOf,
+		Hashtags:  database.Hashtags(dbChirp.Body),
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+	}
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string `json:"body"`
+		InReplyTo int    `json:"in_reply_to"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -45,29 +75,31 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	cleaned, masked, err := validateChirp(params.Body)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	chirp, err := cfg.DB.CreateChirp(cleaned, userID)
+	chirp, err := cfg.DB.CreateChirp(cleaned, masked, userID, params.InReplyTo)
+	if errors.Is(err, database.ErrMissingReference) {
+		respondWithError(w, http.StatusBadRequest, "Chirp being replied to doesn't exist")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:       chirp.ID,
-		AuthorID: chirp.AuthorID,
-		Body:     chirp.Body,
-	})
+	cfg.respondWithChirp(w, http.StatusCreated, chirp)
 }
 
-func validateChirp(body string) (string, error) {
+// validateChirp checks a chirp's body and masks its bad words, reporting
+// whether any were masked.
+func validateChirp(body string) (string, bool, error) {
 	const maxChirpLength = 140
 	if len(body) > maxChirpLength {
-		return "", errors.New("Chirp is too long")
+		return "", false, errors.New("Chirp is too long")
 	}
 
 	badWords := map[string]struct{}{
@@ -75,18 +107,20 @@ func validateChirp(body string) (string, error) {
 		"sharbert":  {},
 		"fornax":    {},
 	}
-	cleaned := getCleanedBody(body, badWords)
-	return cleaned, nil
+	cleaned, masked := getCleanedBody(body, badWords)
+	return cleaned, masked, nil
 }
 
-func getCleanedBody(body string, badWords map[string]struct{}) string {
+func getCleanedBody(body string, badWords map[string]struct{}) (string, bool) {
+	masked := false
 	words := strings.Split(body, " ")
 	for i, word := range words {
 		loweredWord := strings.ToLower(word)
 		if _, ok := badWords[loweredWord]; ok {
-			words[i] = "****"
+			words[i] = database.MaskedWord
+			masked = true
 		}
 	}
 	cleaned := strings.Join(words, " ")
-	return cleaned
+	return cleaned, masked
 }
\ No newline at end of file
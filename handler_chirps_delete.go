@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/S0han/chirpy/webhooks/auth"
+	"github.com/S0han/chirpy/webhooks/database"
 )
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +43,18 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = cfg.DB.DeleteChirp(chirpID)
+	ifVersion, ok := checkIfMatch(w, r, dbChirp.Version, func() string {
+		return cfg.presentedETag(dbChirp)
+	})
+	if !ok {
+		return
+	}
+
+	err = cfg.DB.DeleteChirp(chirpID, userID, ifVersion)
+	if errors.Is(err, database.ErrVersionConflict) {
+		respondWithError(w, http.StatusPreconditionFailed, "Chirp has been modified")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp")
 		return
@@ -2,8 +2,9 @@ package main
 
 import (
 	"net/http"
-	"sort"
 	"strconv"
+
+	"github.com/S0han/chirpy/webhooks/database"
 )
 
 func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
@@ -20,56 +21,66 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:       dbChirp.ID,
-		AuthorID: dbChirp.AuthorID,
-		Body:     dbChirp.Body,
-	})
+	chirp, err := cfg.presentChirp(dbChirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
+	}
+
+	tag := chirpETag(dbChirp.Version, chirp)
+	w.Header().Set("ETag", tag)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
 }
 
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
-	
 	authorIDString := r.URL.Query().Get("author_id")
-	sortOrder := r.URL.Query().Get("sort")
-	
-	dbChirps, err := cfg.DB.GetChirps()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+	sortKey, sortOrder, ok := parseChirpSort(w, r)
+	if !ok {
 		return
 	}
 
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		chirp := Chirp{
-			ID:       dbChirp.ID,
-			AuthorID: dbChirp.AuthorID,
-			Body:     dbChirp.Body,
+	var dbChirps []database.Chirp
+	var err error
+	if authorIDString != "" {
+		authorID, convErr := strconv.Atoi(authorIDString)
+		if convErr != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+			return
 		}
+		dbChirps, err = cfg.DB.GetChirpsByAuthor(authorID, sortKey, sortOrder)
+	} else {
+		dbChirps, err = cfg.DB.GetChirps(sortKey, sortOrder)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
 
-		if authorIDString != "" {
-			authorID, err := strconv.Atoi(authorIDString)
-			if err != nil {
-				respondWithError(w, http.StatusBadRequest, "Invalid author ID")
-				return
-			}
+	cfg.respondWithChirps(w, dbChirps)
+}
 
-			if chirp.AuthorID == authorID {
-				chirps = append(chirps, chirp)
-			}
-		} else {
-			chirps = append(chirps, chirp)
-		}
+// parseChirpSort reads how to sort a chirp listing from ?sort=asc|desc and
+// ?sort_by=id|created_at|updated_at. If they are invalid, it sends a 400
+// and returns false.
+func parseChirpSort(w http.ResponseWriter, r *http.Request) (database.SortKey, database.SortOrder, bool) {
+	sortOrder := database.SortAsc
+	if r.URL.Query().Get("sort") == "desc" {
+		sortOrder = database.SortDesc
 	}
-
-	if sortOrder == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID > chirps[j].ID
-		})
-	} else {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID < chirps[j].ID
-		})
+	sortKey := database.SortByID
+	switch r.URL.Query().Get("sort_by") {
+	case "", "id":
+	case "created_at":
+		sortKey = database.SortByCreatedAt
+	case "updated_at":
+		sortKey = database.SortByUpdatedAt
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid sort_by")
+		return 0, 0, false
 	}
-
-	respondWithJSON(w, http.StatusOK, chirps)
+	return sortKey, sortOrder, true
 }
\ No newline at end of file
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handlerChirpsHistory lists every body a chirp has had, oldest first. The
+// last revision is the current body.
+func (cfg *apiConfig) handlerChirpsHistory(w http.ResponseWriter, r *http.Request) {
+	type revision struct {
+		Revision  int       `json:"revision"`
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	history, err := cfg.DB.GetChirpHistory(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+
+	revisions := []revision{}
+	for i, rev := range history {
+		revisions = append(revisions, revision{
+			Revision:  i + 1,
+			Body:      rev.Body,
+			CreatedAt: rev.CreatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, revisions)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/auth"
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+const (
+	defaultLikesLimit = 20
+	maxLikesLimit     = 100
+)
+
+// handlerChirpsLike likes a chirp on behalf of the user, or takes the like
+// back for DELETE. Both can be repeated safely, and respond with the
+// chirp's like count afterwards.
+func (cfg *apiConfig) handlerChirpsLike(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ChirpID   int  `json:"chirp_id"`
+		Liked     bool `json:"liked"`
+		LikeCount int  `json:"like_count"`
+	}
+
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	// A like of a rechirp counts for the chirp it shares.
+	liked := r.Method != http.MethodDelete
+	if liked {
+		var like database.Like
+		like, err = cfg.DB.LikeChirp(chirpID, userID)
+		chirpID = like.ChirpID
+	} else {
+		chirpID, err = cfg.DB.UnlikeChirp(chirpID, userID)
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update like")
+		return
+	}
+
+	stats, err := cfg.DB.GetChirpStats([]int{chirpID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count likes")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		ChirpID:   chirpID,
+		Liked:     liked,
+		LikeCount: stats[chirpID].Likes,
+	})
+}
+
+// handlerChirpsLikes lists who likes a chirp, newest first, ?limit= at a
+// time. next_before is passed as ?before= to get the next page.
+func (cfg *apiConfig) handlerChirpsLikes(w http.ResponseWriter, r *http.Request) {
+	type like struct {
+		UserID    int       `json:"user_id"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	type response struct {
+		Likes      []like `json:"likes"`
+		NextBefore int    `json:"next_before,omitempty"`
+	}
+
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+	before, limit, ok := parseLikesPage(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := cfg.DB.GetChirpLikes(chirpID, before, limit)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+
+	resp := response{Likes: []like{}, NextBefore: page.NextBefore}
+	for _, dbLike := range page.Likes {
+		resp.Likes = append(resp.Likes, like{UserID: dbLike.UserID, CreatedAt: dbLike.CreatedAt})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerUsersLikes lists the chirps a user likes, most recently liked
+// first, paged like handlerChirpsLikes.
+func (cfg *apiConfig) handlerUsersLikes(w http.ResponseWriter, r *http.Request) {
+	type like struct {
+		Chirp     Chirp     `json:"chirp"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	type response struct {
+		Likes      []like `json:"likes"`
+		NextBefore int    `json:"next_before,omitempty"`
+	}
+
+	userIDString := r.PathValue("userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	before, limit, ok := parseLikesPage(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := cfg.DB.GetUserLikes(userID, before, limit)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+
+	ids := make([]int, len(page.Likes))
+	for i, dbLike := range page.Likes {
+		ids[i] = dbLike.ChirpID
+	}
+	dbChirps, err := cfg.DB.GetChirpsByID(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	// A chirp may have been purged since its like was read.
+	dbLikes := []database.Like{}
+	ordered := []database.Chirp{}
+	for _, dbLike := range page.Likes {
+		if dbChirp, ok := dbChirps[dbLike.ChirpID]; ok {
+			dbLikes = append(dbLikes, dbLike)
+			ordered = append(ordered, dbChirp)
+		}
+	}
+	chirps, err := cfg.presentChirps(ordered)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+
+	resp := response{Likes: []like{}, NextBefore: page.NextBefore}
+	for i, dbLike := range dbLikes {
+		resp.Likes = append(resp.Likes, like{Chirp: chirps[i], CreatedAt: dbLike.CreatedAt})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// parseLikesPage reads ?before= and ?limit= for a listing of likes. If
+// they are invalid, it sends a 400 and returns false.
+func parseLikesPage(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	before := 0
+	if s := r.URL.Query().Get("before"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid before")
+			return 0, 0, false
+		}
+		before = n
+	}
+	limit := defaultLikesLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxLikesLimit {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return 0, 0, false
+		}
+		limit = n
+	}
+	return before, limit, true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// handlerChirpsReplies lists the chirps replying directly to a chirp. The
+// chirp itself may have been deleted.
+func (cfg *apiConfig) handlerChirpsReplies(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+	sortKey, sortOrder, ok := parseChirpSort(w, r)
+	if !ok {
+		return
+	}
+
+	dbChirps, err := cfg.DB.GetReplies(chirpID, sortKey, sortOrder)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+
+	cfg.respondWithChirps(w, dbChirps)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// handlerChirpsSearch finds chirps matching ?q=, best match first. Quoted
+// text matches a phrase and a word ending in * matches as a prefix.
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Chirp
+		Score float64 `json:"score"`
+	}
+
+	limit := defaultSearchLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	dbResults, err := cfg.DB.SearchChirps(r.URL.Query().Get("q"), limit)
+	if errors.Is(err, database.ErrEmptyQuery) {
+		respondWithError(w, http.StatusBadRequest, "Search query has no words")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+
+	dbChirps := make([]database.Chirp, len(dbResults))
+	for i, dbResult := range dbResults {
+		dbChirps[i] = dbResult.Chirp
+	}
+	chirps, err := cfg.presentChirps(dbChirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+
+	results := []result{}
+	for i, dbResult := range dbResults {
+		results = append(results, result{
+			Chirp: chirps[i],
+			Score: dbResult.Score,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// handlerAdminSearchRebuild rebuilds the search index from the store.
+func (cfg *apiConfig) handlerAdminSearchRebuild(w http.ResponseWriter, r *http.Request) {
+	err := cfg.DB.RebuildSearchIndex()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rebuild search index")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/auth"
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+// handlerChirpsUndelete brings back a deleted chirp. Its author can do so
+// within the undelete window; an admin can do so until it is purged.
+func (cfg *apiConfig) handlerChirpsUndelete(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	isAdmin, err := cfg.isAdmin(r)
+	if err == nil && !isAdmin {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+	userID := 0
+	if !isAdmin {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+			return
+		}
+		subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+			return
+		}
+		userID, err = strconv.Atoi(subject)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+			return
+		}
+	}
+
+	dbChirp, err := cfg.DB.GetDeletedChirp(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find deleted chirp")
+		return
+	}
+	if !isAdmin {
+		if dbChirp.AuthorID != userID {
+			respondWithError(w, http.StatusForbidden, "You can't restore this chirp")
+			return
+		}
+		if time.Since(*dbChirp.DeletedAt) > cfg.undeleteWindow {
+			respondWithError(w, http.StatusGone, "Chirp was deleted too long ago to restore")
+			return
+		}
+	}
+
+	dbChirp, err = cfg.DB.UndeleteChirp(chirpID)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		respondWithError(w, http.StatusConflict, "You've rechirped that chirp again since")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore chirp")
+		return
+	}
+
+	cfg.respondWithChirp(w, http.StatusOK, dbChirp)
+}
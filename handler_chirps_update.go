@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/auth"
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+// handlerChirpsUpdate lets the author of a chirp change its body within the
+// edit window. The old body is kept in the chirp's history.
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+
+	cleaned, masked, err := validateChirp(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if dbChirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp")
+		return
+	}
+	if dbChirp.RechirpOf != 0 {
+		respondWithError(w, http.StatusBadRequest, "Rechirps can't be edited")
+		return
+	}
+	if time.Since(dbChirp.CreatedAt) > cfg.editWindow {
+		respondWithError(w, http.StatusForbidden, "Chirp was posted too long ago to edit")
+		return
+	}
+
+	ifVersion, ok := checkIfMatch(w, r, dbChirp.Version, func() string {
+		return cfg.presentedETag(dbChirp)
+	})
+	if !ok {
+		return
+	}
+
+	dbChirp, err = cfg.DB.UpdateChirp(chirpID, cleaned, masked, ifVersion)
+	if errors.Is(err, database.ErrVersionConflict) {
+		respondWithError(w, http.StatusPreconditionFailed, "Chirp has been modified")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+		return
+	}
+
+	cfg.respondWithChirp(w, http.StatusOK, dbChirp)
+}
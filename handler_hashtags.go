@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+const (
+	defaultTrendingLimit = 10
+	maxTrendingLimit     = 100
+)
+
+// handlerHashtagsChirps lists the chirps using a hashtag, which can be
+// given in any case and with or without its #.
+func (cfg *apiConfig) handlerHashtagsChirps(w http.ResponseWriter, r *http.Request) {
+	by, order, ok := parseChirpSort(w, r)
+	if !ok {
+		return
+	}
+
+	dbChirps, err := cfg.DB.GetChirpsByHashtag(r.PathValue("tag"), by, order)
+	if errors.Is(err, database.ErrInvalidHashtag) {
+		respondWithError(w, http.StatusBadRequest, "Invalid hashtag")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+	cfg.respondWithChirps(w, dbChirps)
+}
+
+// handlerTrending ranks the hashtags used over ?window=, which has to be
+// one of cfg.trendingWindows, favouring recent uses.
+func (cfg *apiConfig) handlerTrending(w http.ResponseWriter, r *http.Request) {
+	type trendingHashtag struct {
+		Tag    string  `json:"tag"`
+		Score  float64 `json:"score"`
+		Chirps int     `json:"chirps"`
+	}
+
+	window := cfg.trendingWindows[0]
+	if s := r.URL.Query().Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || !slices.Contains(cfg.trendingWindows, d) {
+			respondWithError(w, http.StatusBadRequest, "Invalid window")
+			return
+		}
+		window = d
+	}
+
+	limit := defaultTrendingLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxTrendingLimit {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	dbTrending, err := cfg.DB.GetTrendingHashtags(window, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trending hashtags")
+		return
+	}
+
+	trending := []trendingHashtag{}
+	for _, t := range dbTrending {
+		trending = append(trending, trendingHashtag{
+			Tag:    t.Tag,
+			Score:  t.Score,
+			Chirps: t.Chirps,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, trending)
+}
+
+// parseDurations parses a comma-separated list of positive durations.
+func parseDurations(s string) ([]time.Duration, error) {
+	durations := []time.Duration{}
+	for _, part := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration %v isn't positive", d)
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
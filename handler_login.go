@@ -60,6 +60,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", etag(user.Version))
 	respondWithJSON(w, http.StatusOK, response{
 		User: User{
 			ID:          user.ID,
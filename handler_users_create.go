@@ -50,6 +50,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	w.Header().Set("ETag", etag(user.Version))
 	respondWithJSON(w, http.StatusCreated, response{
 		User: User{
 			ID:          user.ID,
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	
 	"github.com/S0han/chirpy/webhooks/auth"
+	"github.com/S0han/chirpy/webhooks/database"
 )
 
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
@@ -48,12 +50,33 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
+	current, err := cfg.DB.GetUser(userIDInt)
 	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user")
+		return
+	}
+	ifVersion, ok := checkIfMatch(w, r, current.Version, func() string {
+		return etag(current.Version)
+	})
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword, ifVersion)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+		if errors.Is(err, database.ErrVersionConflict) {
+			respondWithError(w, http.StatusPreconditionFailed, "User has been modified")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
 		return
 	}
 
+	w.Header().Set("ETag", etag(user.Version))
 	respondWithJSON(w, http.StatusOK, response{
 		User: User{
 			ID:          user.ID,
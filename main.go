@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/S0han/chirpy/webhooks/database"
 	"github.com/joho/godotenv"
@@ -12,28 +20,93 @@ import (
 
 type apiConfig struct {
 	fileserverHits int
-	DB             *database.DB
+	DB             database.Store
 	jwtSecret      string
+	adminAPIKey    string
+	undeleteWindow time.Duration
+	editWindow     time.Duration
+	// trendingWindows are the windows GET /api/trending can rank over. The
+	// first is the default.
+	trendingWindows []time.Duration
+	// refreshTokensPurged counts the expired refresh tokens removed by the
+	// token janitor since startup.
+	refreshTokensPurged *atomic.Int64
+
+	// follower is set when this instance replicates from a leader.
+	follower          *follower
+	maxReplicationLag time.Duration
 }
 
 func main() {
 	const filepathRoot = "."
-	const port = "8080"
 
 	godotenv.Load(".env")
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	dbg := flag.Bool("debug", false, "Enable debug mode")
+	port := flag.String("port", "8080", "Port to listen on")
+	storeKind := flag.String("store", "json", "Storage backend: json, sqlite or memory")
+	dbPath := flag.String("db", "", "Path to the database file (defaults to database.json or chirpy.db)")
+	flushInterval := flag.Duration("flush-interval", 0, "Batch JSON database writes at this interval instead of writing on every change")
+	undeleteWindow := flag.Duration("undelete-window", 24*time.Hour, "How long after deleting a chirp its author can restore it")
+	editWindow := flag.Duration("edit-window", 15*time.Minute, "How long after posting a chirp its author can edit it")
+	chirpRetention := flag.Duration("chirp-retention", 30*24*time.Hour, "How long deleted chirps are kept before they are purged")
+	tokenGCInterval := flag.Duration("token-gc-interval", 10*time.Minute, "How often expired refresh tokens are removed")
+	wal := flag.Bool("wal", false, "Append JSON database changes to a write-ahead log instead of rewriting the file")
+	readOnly := flag.Bool("read-only", false, "Serve GET requests from a JSON database another instance writes to, reloading it when it changes")
+	follow := flag.String("follow", "", "Base URL of a leader to replicate from; writes are sent there")
+	followRedirect := flag.Bool("follow-redirect", false, "Redirect writes to the leader instead of proxying them")
+	maxReplicationLag := flag.Duration("max-replication-lag", 30*time.Second, "How long a follower can go without hearing from its leader before reporting unhealthy")
+	trendingWindows := flag.String("trending-windows", "24h,1h,168h", "Comma-separated windows trending hashtags can be ranked over; the first is the default")
+	keyFile := flag.String("db-key-file", "", "File of id:base64key lines to encrypt the JSON database with (defaults to DB_ENCRYPTION_KEYS)")
+	flag.Parse()
+
+	keys, err := loadKeyring(*keyFile)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	db, err := database.NewDB("database.json")
+	if flag.NArg() > 0 {
+		err := runCommand(*storeKind, *dbPath, database.Options{Keys: keys}, flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	windows, err := parseDurations(*trendingWindows)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	dbg := flag.Bool("debug", false, "Enable debug mode")
-	flag.Parse()
+	var db database.Store
+	var leader *url.URL
+	if *follow != "" {
+		if *readOnly {
+			log.Fatal("-follow and -read-only can't be combined")
+		}
+		leader, err = url.Parse(*follow)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*storeKind = "replica"
+		db = database.NewReplicaDB()
+	} else {
+		db, err = openStore(*storeKind, *dbPath, database.Options{
+			FlushInterval: *flushInterval,
+			WAL:           *wal,
+			Keys:          keys,
+			ReadOnly:      *readOnly,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatal("JWT_SECRET environment variable is not set")
+	}
+
 	if dbg != nil && *dbg {
 		err := db.ResetDB()
 		if err != nil {
@@ -45,6 +118,17 @@ func main() {
 		fileserverHits: 0,
 		DB:             db,
 		jwtSecret:      jwtSecret,
+		adminAPIKey:    os.Getenv("ADMIN_API_KEY"),
+		undeleteWindow: *undeleteWindow,
+		editWindow:     *editWindow,
+
+		trendingWindows: windows,
+
+		refreshTokensPurged: &atomic.Int64{},
+		maxReplicationLag:   *maxReplicationLag,
+	}
+	if leader != nil {
+		apiCfg.follower = newFollower(leader, apiCfg.adminAPIKey, db.(*database.DB))
 	}
 
 	mux := http.NewServeMux()
@@ -52,6 +136,7 @@ func main() {
 	mux.Handle("/app/*", fsHandler)
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /api/healthz/replication", apiCfg.handlerReplicationHealth)
 	mux.HandleFunc("GET /api/reset", apiCfg.handlerReset)
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerWebhook)
@@ -62,19 +147,83 @@ func main() {
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("GET /api/users/{userID}/likes", apiCfg.handlerUsersLikes)
 
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerChirpsUpdate)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/history", apiCfg.handlerChirpsHistory)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/replies", apiCfg.handlerChirpsReplies)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/conversation", apiCfg.handlerChirpsConversation)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/undelete", apiCfg.handlerChirpsUndelete)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", apiCfg.handlerChirpsRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", apiCfg.handlerChirpsUnrechirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/quote", apiCfg.handlerChirpsQuote)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", apiCfg.handlerChirpsLike)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.handlerChirpsLike)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/likes", apiCfg.handlerChirpsLikes)
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsRetrieve)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerChirpsSearch)
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiCfg.handlerHashtagsChirps)
+	mux.HandleFunc("GET /api/trending", apiCfg.handlerTrending)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGet)
 
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.HandleFunc("GET /admin/backup", apiCfg.middlewareAdmin(apiCfg.handlerAdminBackup))
+	mux.HandleFunc("GET /admin/export", apiCfg.middlewareAdmin(apiCfg.handlerAdminExport))
+	mux.HandleFunc("POST /admin/import", apiCfg.middlewareAdmin(apiCfg.handlerAdminImport))
+	mux.HandleFunc("GET /admin/fsck", apiCfg.middlewareAdmin(apiCfg.handlerAdminFsck))
+	mux.HandleFunc("POST /admin/fsck", apiCfg.middlewareAdmin(apiCfg.handlerAdminFsck))
+	mux.HandleFunc("POST /admin/search/rebuild", apiCfg.middlewareAdmin(apiCfg.handlerAdminSearchRebuild))
+
+	mux.HandleFunc("GET /internal/replication/snapshot", apiCfg.middlewareAdmin(apiCfg.handlerReplicationSnapshot))
+	mux.HandleFunc("GET /internal/replication/log", apiCfg.middlewareAdmin(apiCfg.handlerReplicationLog))
+
+	var handler http.Handler = mux
+	if *readOnly {
+		handler = middlewareReadOnly(mux)
+	}
+	if leader != nil {
+		handler = middlewareFollower(mux, leader, *followRedirect)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:    ":" + *port,
+		Handler: handler,
+		// Cancel long polls from followers on shutdown too.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	if apiCfg.follower != nil {
+		go apiCfg.follower.run(ctx)
+	} else if !*readOnly {
+		go runChirpPurger(ctx, db, *chirpRetention, time.Hour)
+		go runTokenJanitor(ctx, db, *tokenGCInterval, apiCfg.refreshTokensPurged)
 	}
 
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+	go func() {
+		log.Printf("Using %s store", *storeKind)
+		log.Printf("Serving files from %s on port: %s\n", filepathRoot, *port)
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Couldn't shut down cleanly: %s", err)
+	}
+	err = db.Close()
+	if err != nil {
+		log.Printf("Couldn't close database: %s", err)
+	}
 }
\ No newline at end of file
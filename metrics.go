@@ -14,10 +14,11 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 <body>
 	<h1>Welcome, Chirpy Admin</h1>
 	<p>Chirpy has been visited %dtimes!</p>
+	<p>Expired refresh tokens removed: %d</p>
 </body>
 
 </html>
-	`, cfg.fileserverHits)))
+	`, cfg.fileserverHits, cfg.refreshTokensPurged.Load())))
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
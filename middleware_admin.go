@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/S0han/chirpy/webhooks/auth"
+)
+
+// middlewareAdmin only lets through requests carrying the admin API key.
+func (cfg *apiConfig) middlewareAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminAPIKey == "" {
+			respondWithError(w, http.StatusForbidden, "Admin API is disabled")
+			return
+		}
+		isAdmin, err := cfg.isAdmin(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find API key")
+			return
+		}
+		if !isAdmin {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether r carries the admin API key. It fails if r has
+// no API key at all, and is false for any key while the admin API is
+// disabled.
+func (cfg *apiConfig) isAdmin(r *http.Request) (bool, error) {
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return false, err
+	}
+	if cfg.adminAPIKey == "" {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) == 1, nil
+}
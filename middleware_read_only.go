@@ -0,0 +1,15 @@
+package main
+
+import "net/http"
+
+// middlewareReadOnly turns away requests that could change anything, for
+// instances serving a read-only copy of the database.
+func middlewareReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			respondWithError(w, http.StatusServiceUnavailable, "This instance is read-only")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
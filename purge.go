@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+// runChirpPurger permanently removes chirps that were deleted more than
+// retention ago, checking every interval until ctx is cancelled.
+func runChirpPurger(ctx context.Context, db database.Store, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := db.PurgeDeletedChirps(time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("Couldn't purge deleted chirps: %s", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d deleted chirps", purged)
+			}
+		}
+	}
+}
+
+// runTokenJanitor removes expired refresh tokens every interval until ctx
+// is cancelled, adding the number removed to removed.
+func runTokenJanitor(ctx context.Context, db database.Store, interval time.Duration, removed *atomic.Int64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := db.PurgeExpiredRefreshTokens(time.Now())
+			if err != nil {
+				log.Printf("Couldn't purge expired refresh tokens: %s", err)
+				continue
+			}
+			if purged > 0 {
+				removed.Add(int64(purged))
+				log.Printf("Purged %d expired refresh tokens", purged)
+			}
+		}
+	}
+}
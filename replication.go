@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+// logPollWait is how long the leader holds a request for new log entries
+// open before answering with none.
+const logPollWait = 25 * time.Second
+
+// replicationSource returns the store as a *database.DB, which is the only
+// store that can be replicated.
+func (cfg *apiConfig) replicationSource(w http.ResponseWriter) (*database.DB, bool) {
+	db, ok := cfg.DB.(*database.DB)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Replication needs the json or memory store")
+	}
+	return db, ok
+}
+
+// handlerReplicationSnapshot sends followers the data to start from.
+func (cfg *apiConfig) handlerReplicationSnapshot(w http.ResponseWriter, r *http.Request) {
+	db, ok := cfg.replicationSource(w)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, db.ReplicaSnapshot())
+}
+
+// handlerReplicationLog sends followers the transactions after ?after=N.
+// With ?wait=true it holds the request open until there are some, for up
+// to logPollWait. 410 Gone means the follower has to start again from a
+// snapshot.
+func (cfg *apiConfig) handlerReplicationLog(w http.ResponseWriter, r *http.Request) {
+	db, ok := cfg.replicationSource(w)
+	if !ok {
+		return
+	}
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid log position")
+		return
+	}
+
+	if r.URL.Query().Get("wait") == "true" {
+		ctx, cancel := context.WithTimeout(r.Context(), logPollWait)
+		defer cancel()
+		db.WaitForLog(ctx, after)
+	}
+
+	batch, err := db.LogSince(after)
+	if errors.Is(err, database.ErrLogTruncated) {
+		respondWithJSON(w, http.StatusGone, batch)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read log")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, batch)
+}
+
+// replicationStatus is what a follower knows about how far behind its
+// leader it is.
+type replicationStatus struct {
+	mu          sync.Mutex
+	epoch       string
+	appliedSeq  uint64
+	leaderSeq   uint64
+	lastContact time.Time
+	lastError   string
+}
+
+// follower keeps a replica up to date with its leader.
+type follower struct {
+	leader *url.URL
+	apiKey string
+	client *http.Client
+	db     *database.DB
+	status *replicationStatus
+}
+
+func newFollower(leader *url.URL, apiKey string, db *database.DB) *follower {
+	return &follower{
+		leader: leader,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: logPollWait + 10*time.Second},
+		db:     db,
+		status: &replicationStatus{},
+	}
+}
+
+// run follows the leader until ctx is cancelled, starting again from a
+// snapshot whenever the leader can't supply the entries it needs.
+func (f *follower) run(ctx context.Context) {
+	needSnapshot := true
+	for {
+		var err error
+		if needSnapshot {
+			err = f.loadSnapshot(ctx)
+		} else {
+			err = f.poll(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == nil:
+			needSnapshot = false
+		case errors.Is(err, database.ErrLogTruncated):
+			log.Printf("Replication from %s lost its place, starting again from a snapshot", f.leader)
+			needSnapshot = true
+		default:
+			f.status.mu.Lock()
+			f.status.lastError = err.Error()
+			f.status.mu.Unlock()
+			log.Printf("Couldn't replicate from %s: %s", f.leader, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (f *follower) loadSnapshot(ctx context.Context) error {
+	snapshot := database.ReplicaSnapshot{}
+	_, err := f.get(ctx, "/internal/replication/snapshot", nil, &snapshot)
+	if err != nil {
+		return err
+	}
+	err = f.db.LoadSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	f.contacted(snapshot.Epoch, snapshot.Data.LogSeq, snapshot.Data.LogSeq)
+	log.Printf("Loaded snapshot from %s at position %d", f.leader, snapshot.Data.LogSeq)
+	return nil
+}
+
+func (f *follower) poll(ctx context.Context) error {
+	f.status.mu.Lock()
+	after, epoch := f.status.appliedSeq, f.status.epoch
+	f.status.mu.Unlock()
+
+	batch := database.LogBatch{}
+	query := url.Values{
+		"after": {strconv.FormatUint(after, 10)},
+		"wait":  {"true"},
+	}
+	status, err := f.get(ctx, "/internal/replication/log", query, &batch)
+	if status == http.StatusGone || err == nil && batch.Epoch != epoch {
+		return database.ErrLogTruncated
+	}
+	if err != nil {
+		return err
+	}
+	err = f.db.ApplyLog(batch.Entries)
+	if err != nil {
+		return err
+	}
+	applied := after
+	if len(batch.Entries) > 0 {
+		applied = batch.Entries[len(batch.Entries)-1].Seq
+	}
+	f.contacted(batch.Epoch, applied, batch.LogSeq)
+	return nil
+}
+
+// get fetches path from the leader into v. A 410 Gone response is
+// decoded too, for its status to be checked.
+func (f *follower) get(ctx context.Context, path string, query url.Values, v any) (int, error) {
+	u := f.leader.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "ApiKey "+f.apiKey)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusGone {
+		return resp.StatusCode, fmt.Errorf("leader responded with %s", resp.Status)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (f *follower) contacted(epoch string, appliedSeq, leaderSeq uint64) {
+	f.status.mu.Lock()
+	defer f.status.mu.Unlock()
+	f.status.epoch = epoch
+	f.status.appliedSeq = appliedSeq
+	f.status.leaderSeq = leaderSeq
+	f.status.lastContact = time.Now()
+	f.status.lastError = ""
+}
+
+// handlerReplicationHealth reports this instance's role and, on a follower,
+// how far behind the leader it is. Followers answer 503 if they haven't
+// heard from the leader within maxReplicationLag of when they should have.
+func (cfg *apiConfig) handlerReplicationHealth(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Role                string     `json:"role"`
+		Leader              string     `json:"leader,omitempty"`
+		LogSeq              uint64     `json:"log_seq"`
+		LeaderSeq           uint64     `json:"leader_seq,omitempty"`
+		LagEntries          uint64     `json:"lag_entries"`
+		LastContact         *time.Time `json:"last_contact,omitempty"`
+		SecondsSinceContact float64    `json:"seconds_since_contact,omitempty"`
+		LastError           string     `json:"last_error,omitempty"`
+	}
+
+	if cfg.follower == nil {
+		resp := response{Role: "leader"}
+		if db, ok := cfg.DB.(*database.DB); ok {
+			resp.LogSeq = db.LogSeq()
+		}
+		respondWithJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	status := cfg.follower.status
+	status.mu.Lock()
+	resp := response{
+		Role:      "follower",
+		Leader:    cfg.follower.leader.String(),
+		LogSeq:    status.appliedSeq,
+		LeaderSeq: status.leaderSeq,
+		LastError: status.lastError,
+	}
+	lastContact := status.lastContact
+	status.mu.Unlock()
+
+	if resp.LeaderSeq > resp.LogSeq {
+		resp.LagEntries = resp.LeaderSeq - resp.LogSeq
+	}
+	if lastContact.IsZero() {
+		respondWithJSON(w, http.StatusServiceUnavailable, resp)
+		return
+	}
+	resp.LastContact = &lastContact
+	resp.SecondsSinceContact = time.Since(lastContact).Seconds()
+	// An idle leader only answers a long poll every logPollWait, so
+	// that much silence is expected.
+	code := http.StatusOK
+	if time.Since(lastContact) > logPollWait+cfg.maxReplicationLag {
+		code = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, code, resp)
+}
+
+// middlewareFollower sends requests that could change anything to the
+// leader, either by proxying them or by redirecting the client there.
+func middlewareFollower(next http.Handler, leader *url.URL, redirect bool) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(leader)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if redirect {
+			target := leader.JoinPath(r.URL.Path)
+			target.RawQuery = r.URL.RawQuery
+			http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+const testAPIKey = "test-key"
+
+// testLeader serves the replication endpoints of a leader whose store can
+// be swapped out, as if it had restarted, and counts the snapshots
+// followers take. If gone is set, the next log request gets 410 Gone.
+type testLeader struct {
+	server    *httptest.Server
+	mux       atomic.Pointer[http.ServeMux]
+	snapshots atomic.Int64
+	gone      atomic.Bool
+}
+
+func newTestLeader(t *testing.T, db *database.DB) *testLeader {
+	t.Helper()
+	l := &testLeader{}
+	l.setDB(db)
+	l.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal/replication/snapshot":
+			l.snapshots.Add(1)
+		case "/internal/replication/log":
+			if l.gone.CompareAndSwap(true, false) {
+				respondWithJSON(w, http.StatusGone, database.LogBatch{})
+				return
+			}
+		}
+		l.mux.Load().ServeHTTP(w, r)
+	}))
+	t.Cleanup(l.server.Close)
+	return l
+}
+
+func (l *testLeader) setDB(db *database.DB) {
+	cfg := &apiConfig{DB: db, adminAPIKey: testAPIKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /internal/replication/snapshot", cfg.middlewareAdmin(cfg.handlerReplicationSnapshot))
+	mux.HandleFunc("GET /internal/replication/log", cfg.middlewareAdmin(cfg.handlerReplicationLog))
+	l.mux.Store(mux)
+}
+
+func (l *testLeader) newFollower(t *testing.T) *follower {
+	t.Helper()
+	leaderURL, err := url.Parse(l.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFollower(leaderURL, testAPIKey, database.NewReplicaDB())
+}
+
+// eventually fails the test if cond isn't true within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func hasChirp(db database.Store, id int, body string) bool {
+	chirp, err := db.GetChirp(id)
+	return err == nil && chirp.Body == body
+}
+
+type replicationHealth struct {
+	Role       string `json:"role"`
+	LogSeq     uint64 `json:"log_seq"`
+	LeaderSeq  uint64 `json:"leader_seq"`
+	LagEntries uint64 `json:"lag_entries"`
+}
+
+func checkReplicationHealth(t *testing.T, f *follower) (int, replicationHealth) {
+	t.Helper()
+	cfg := &apiConfig{follower: f, maxReplicationLag: time.Minute}
+	rec := httptest.NewRecorder()
+	cfg.handlerReplicationHealth(rec, httptest.NewRequest(http.MethodGet, "/api/healthz/replication", nil))
+	health := replicationHealth{}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, health
+}
+
+func TestReplication(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	leaderDB, err := database.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader := newTestLeader(t, leaderDB)
+	user, err := leaderDB.CreateUser("a@b.c", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := leader.newFollower(t)
+	if code, _ := checkReplicationHealth(t, f); code != http.StatusServiceUnavailable {
+		t.Errorf("health before contacting the leader: got %d, want 503", code)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	chirp, err := leaderDB.CreateChirp("first", false, user.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the chirp to be replicated", func() bool {
+		return hasChirp(f.db, chirp.ID, "first")
+	})
+	eventually(t, "the follower to report it has caught up", func() bool {
+		_, health := checkReplicationHealth(t, f)
+		return health.LogSeq == leaderDB.LogSeq()
+	})
+	code, health := checkReplicationHealth(t, f)
+	if code != http.StatusOK || health.Role != "follower" || health.LagEntries != 0 {
+		t.Errorf("health after catching up: got %d %+v", code, health)
+	}
+	if n := leader.snapshots.Load(); n != 1 {
+		t.Fatalf("got %d snapshots, want 1", n)
+	}
+
+	t.Run("410 Gone", func(t *testing.T) {
+		leader.gone.Store(true)
+		chirp, err := leaderDB.CreateChirp("after gone", false, user.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "a new snapshot", func() bool {
+			return leader.snapshots.Load() == 2
+		})
+		eventually(t, "the chirp to be replicated", func() bool {
+			return hasChirp(f.db, chirp.ID, "after gone")
+		})
+	})
+
+	t.Run("leader restart", func(t *testing.T) {
+		if err := leaderDB.Close(); err != nil {
+			t.Fatal(err)
+		}
+		leaderDB, err = database.NewDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer leaderDB.Close()
+		snapshots := leader.snapshots.Load()
+		leader.setDB(leaderDB)
+		// Drop the long poll still waiting on the old store.
+		leader.server.CloseClientConnections()
+
+		chirp, err := leaderDB.CreateChirp("after restart", false, user.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "a new snapshot", func() bool {
+			return leader.snapshots.Load() > snapshots
+		})
+		eventually(t, "the chirp to be replicated", func() bool {
+			return hasChirp(f.db, chirp.ID, "after restart")
+		})
+	})
+}
+
+func TestReplicationLag(t *testing.T) {
+	leaderDB := database.NewMemDB()
+	leader := newTestLeader(t, leaderDB)
+	f := leader.newFollower(t)
+	ctx := context.Background()
+
+	if err := f.loadSnapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// More transactions than fit in one batch leave the follower behind
+	// after a single poll.
+	const extra = 5
+	for i := 0; i < 1000+extra; i++ {
+		if _, err := leaderDB.CreateChirp("chirp", false, 1, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	code, health := checkReplicationHealth(t, f)
+	if code != http.StatusOK || health.LagEntries != extra || health.LeaderSeq != leaderDB.LogSeq() {
+		t.Errorf("health after one poll: got %d %+v, want %d entries behind %d", code, health, extra, leaderDB.LogSeq())
+	}
+
+	if err := f.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, health := checkReplicationHealth(t, f); health.LagEntries != 0 {
+		t.Errorf("health after catching up: got %+v", health)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/S0han/chirpy/webhooks/database"
+)
+
+func openStore(kind, path string, opts database.Options) (database.Store, error) {
+	path = storePath(kind, path)
+	switch kind {
+	case "json":
+		return database.NewDBWithOptions(path, opts)
+	case "sqlite":
+		return database.NewSQLiteDB(path)
+	case "memory":
+		return database.NewMemDB(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+func storePath(kind, path string) string {
+	if path != "" {
+		return path
+	}
+	switch kind {
+	case "json":
+		return "database.json"
+	case "sqlite":
+		return "chirpy.db"
+	}
+	return ""
+}
+
+// loadKeyring reads the database encryption keys from keyFile or, if that
+// isn't set, the DB_ENCRYPTION_KEYS environment variable. It returns nil
+// if neither is configured.
+func loadKeyring(keyFile string) (*database.Keyring, error) {
+	keys := os.Getenv("DB_ENCRYPTION_KEYS")
+	if keyFile != "" {
+		dat, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = string(dat)
+	}
+	if keys == "" {
+		return nil, nil
+	}
+	keyring, err := database.ParseKeyring(keys)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load encryption keys: %w", err)
+	}
+	return keyring, nil
+}
@@ -1,72 +1,397 @@
 package database
 
+import (
+	"sort"
+	"time"
+)
+
 type Chirp struct {
 	ID       int    `json:"id"`
 	AuthorID int    `json:"author_id"`
 	Body     string `json:"body"`
+	// Masked is set when the profanity filter put MaskedWord in Body.
+	Masked bool `json:"masked,omitempty"`
+	// InReplyTo is the ID of the chirp this one replies to, or zero.
+	InReplyTo int `json:"in_reply_to,omitempty"`
+	// RechirpOf is set on a rechirp, which shares another chirp and has no
+	// body of its own. QuoteOf is set on a quote, which has a body and
+	// shows the chirp it quotes.
+	RechirpOf int `json:"rechirp_of,omitempty"`
+	QuoteOf   int `json:"quote_of,omitempty"`
+	// CreatedAt is when the chirp was posted and UpdatedAt when its body
+	// was last set, by posting or editing it.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// History holds the bodies an edited chirp had before, oldest first.
+	// Listings may leave it out; use GetChirpHistory to read it.
+	History []ChirpRevision `json:"history,omitempty"`
+	// DeletedAt is set when the chirp has been deleted. Deleted chirps are
+	// kept as tombstones, hidden from every listing, until they are purged.
+	// Tombstones with replies or quotes are kept, so conversations stay
+	// connected. While a chirp is deleted, its rechirps are hidden too, and
+	// they are purged along with it.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy int        `json:"deleted_by,omitempty"`
+	// Version starts at 1 and goes up by one on every change.
+	Version int `json:"version"`
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
+func (c Chirp) Deleted() bool {
+	return c.DeletedAt != nil
+}
+
+// ChirpRevision is one body a chirp has had and when it was set.
+type ChirpRevision struct {
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SortKey is what chirp listings are sorted by. Chirps with the same
+// timestamp are sorted by ID.
+type SortKey int
+
+const (
+	SortByID SortKey = iota
+	SortByCreatedAt
+	SortByUpdatedAt
+)
+
+// SortOrder is the direction in which chirp listings are sorted.
+type SortOrder int
+
+const (
+	SortAsc SortOrder = iota
+	SortDesc
+)
+
+// CreateChirp posts a chirp, replying to the chirp with ID inReplyTo unless
+// it is zero. masked says whether the profanity filter changed body. It
+// fails with ErrMissingReference if the chirp replied to doesn't exist.
+func (db *DB) CreateChirp(body string, masked bool, authorID, inReplyTo int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.CreateChirp(body, masked, authorID, inReplyTo)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) GetChirps(by SortKey, order SortOrder) ([]Chirp, error) {
+	var chirps []Chirp
+	err := db.View(func(tx *Tx) error {
+		chirps = tx.GetChirps(by, order)
+		return nil
+	})
+	return chirps, err
+}
+
+func (db *DB) GetChirpsByAuthor(authorID int, by SortKey, order SortOrder) ([]Chirp, error) {
+	var chirps []Chirp
+	err := db.View(func(tx *Tx) error {
+		chirps = tx.GetChirpsByAuthor(authorID, by, order)
+		return nil
+	})
+	return chirps, err
+}
+
+func (db *DB) GetChirp(id int) (Chirp, error) {
+	var chirp Chirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.GetChirp(id)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) GetDeletedChirp(id int) (Chirp, error) {
+	var chirp Chirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.GetDeletedChirp(id)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) GetChirpHistory(id int) ([]ChirpRevision, error) {
+	var history []ChirpRevision
+	err := db.View(func(tx *Tx) error {
+		var err error
+		history, err = tx.GetChirpHistory(id)
+		return err
+	})
+	return history, err
+}
+
+// UpdateChirp replaces the body of a chirp, keeping the old one in its
+// history. If ifVersion isn't zero, the chirp is only updated if it is
+// still at that version.
+func (db *DB) UpdateChirp(id int, body string, masked bool, ifVersion int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.UpdateChirp(id, body, masked, ifVersion)
+		return err
+	})
+	return chirp, err
+}
+
+// DeleteChirp deletes a chirp. If ifVersion isn't zero, the chirp is only
+// deleted if it is still at that version.
+func (db *DB) DeleteChirp(id, deletedBy, ifVersion int) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.DeleteChirp(id, deletedBy, ifVersion)
+	})
+}
+
+func (db *DB) UndeleteChirp(id int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.UndeleteChirp(id)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	var purged int
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		purged, err = tx.PurgeDeletedChirps(deletedBefore)
+		return err
+	})
+	return purged, err
+}
+
+func (tx *Tx) CreateChirp(body string, masked bool, authorID, inReplyTo int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
 		return Chirp{}, err
 	}
+	if inReplyTo != 0 {
+		parent, err := tx.GetChirp(inReplyTo)
+		if err != nil {
+			return Chirp{}, ErrMissingReference
+		}
+		inReplyTo = originalID(parent)
+	}
 
-	id := len(dbStructure.Chirps) + 1
+	id := tx.nextChirpID()
+	now := time.Now().UTC()
 	chirp := Chirp{
-		ID:       id,
-		Body:     body,
-		AuthorID: authorID,
+		ID:        id,
+		Body:      body,
+		Masked:    masked,
+		AuthorID:  authorID,
+		InReplyTo: inReplyTo,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
 	}
-	dbStructure.Chirps[id] = chirp
+	tx.putChirp(chirp)
+	tx.emit(ChirpCreated{Chirp: chirp})
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return Chirp{}, err
+	return chirp, nil
+}
+
+func (tx *Tx) GetChirps(by SortKey, order SortOrder) []Chirp {
+	chirps := make([]Chirp, 0, len(tx.data.Chirps))
+	for _, chirp := range tx.data.Chirps {
+		if tx.visible(chirp) {
+			chirps = append(chirps, chirp)
+		}
 	}
+	SortChirps(chirps, by, order)
+	return chirps
+}
 
-	return chirp, nil
+func (tx *Tx) GetChirpsByAuthor(authorID int, by SortKey, order SortOrder) []Chirp {
+	ids := tx.data.idx.chirpsByAuthor[authorID]
+	chirps := make([]Chirp, 0, len(ids))
+	for i := range ids {
+		if order == SortDesc {
+			i = len(ids) - 1 - i
+		}
+		chirp := tx.data.Chirps[ids[i]]
+		if tx.visible(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	if by != SortByID {
+		SortChirps(chirps, by, order)
+	}
+	return chirps
 }
 
-func (db *DB) GetChirps() ([]Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return nil, err
+func (tx *Tx) GetChirp(id int) (Chirp, error) {
+	chirp, ok := tx.data.Chirps[id]
+	if !ok || !tx.visible(chirp) {
+		return Chirp{}, ErrNotExist
 	}
+	return chirp, nil
+}
 
-	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
-	for _, chirp := range dbStructure.Chirps {
-		chirps = append(chirps, chirp)
+// visible reports whether chirp shows up in listings: it hasn't been
+// deleted and, if it is a rechirp, neither has what it shares.
+func (tx *Tx) visible(chirp Chirp) bool {
+	if chirp.Deleted() {
+		return false
 	}
+	if chirp.RechirpOf == 0 {
+		return true
+	}
+	original, ok := tx.data.Chirps[chirp.RechirpOf]
+	return ok && !original.Deleted()
+}
 
-	return chirps, nil
+// GetDeletedChirp returns the tombstone of a deleted chirp.
+func (tx *Tx) GetDeletedChirp(id int) (Chirp, error) {
+	chirp, ok := tx.data.Chirps[id]
+	if !ok || !chirp.Deleted() {
+		return Chirp{}, ErrNotExist
+	}
+	return chirp, nil
 }
 
-func (db *DB) GetChirp(id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+// GetChirpHistory returns every body a chirp has had, oldest first and
+// ending with the current one.
+func (tx *Tx) GetChirpHistory(id int) ([]ChirpRevision, error) {
+	chirp, err := tx.GetChirp(id)
 	if err != nil {
+		return nil, err
+	}
+	history := append([]ChirpRevision(nil), chirp.History...)
+	return append(history, ChirpRevision{Body: chirp.Body, CreatedAt: chirp.UpdatedAt}), nil
+}
+
+func (tx *Tx) UpdateChirp(id int, body string, masked bool, ifVersion int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
 		return Chirp{}, err
 	}
 
-	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
-		return Chirp{}, ErrNotExist
+	chirp, err := tx.GetChirp(id)
+	if err != nil {
+		return Chirp{}, err
 	}
+	if ifVersion != 0 && chirp.Version != ifVersion {
+		return Chirp{}, ErrVersionConflict
+	}
+	// Copy the history so that the version already stored isn't changed
+	// underneath a rollback.
+	history := make([]ChirpRevision, 0, len(chirp.History)+1)
+	history = append(history, chirp.History...)
+	chirp.History = append(history, ChirpRevision{Body: chirp.Body, CreatedAt: chirp.UpdatedAt})
+	chirp.Body = body
+	chirp.Masked = masked
+	chirp.UpdatedAt = time.Now().UTC()
+	chirp.Version++
+	tx.putChirp(chirp)
+	tx.emit(ChirpEdited{ChirpID: id, AuthorID: chirp.AuthorID, Body: body})
 
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
+// DeleteChirp replaces a chirp with a tombstone recording when and by whom
+// it was deleted.
+func (tx *Tx) DeleteChirp(id, deletedBy, ifVersion int) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
 
-	delete(dbStructure.Chirps, id)
-	err = db.writeDB(dbStructure)
+	chirp, err := tx.GetChirp(id)
 	if err != nil {
 		return err
 	}
+	if ifVersion != 0 && chirp.Version != ifVersion {
+		return ErrVersionConflict
+	}
+	now := time.Now().UTC()
+	chirp.DeletedAt = &now
+	chirp.DeletedBy = deletedBy
+	chirp.Version++
+	tx.putChirp(chirp)
+	tx.emit(ChirpDeleted{ChirpID: id, AuthorID: chirp.AuthorID, DeletedBy: deletedBy})
 
 	return nil
-}
\ No newline at end of file
+}
+
+func (tx *Tx) UndeleteChirp(id int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, err := tx.GetDeletedChirp(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.RechirpOf != 0 {
+		if _, ok := tx.findRechirp(chirp.RechirpOf, chirp.AuthorID); ok {
+			return Chirp{}, ErrAlreadyExists
+		}
+	}
+	chirp.DeletedAt = nil
+	chirp.DeletedBy = 0
+	chirp.Version++
+	tx.putChirp(chirp)
+	tx.emit(ChirpUndeleted{Chirp: chirp})
+
+	return chirp, nil
+}
+
+// PurgeDeletedChirps permanently removes the tombstones of chirps deleted
+// before deletedBefore that have no replies or quotes left, along with
+// their rechirps and likes, and returns how many chirps that was.
+func (tx *Tx) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	// Purging a reply or quote can leave what it referred to with none,
+	// so go round until nothing changes.
+	for {
+		n := 0
+		for id, chirp := range tx.data.Chirps {
+			if !chirp.Deleted() || !chirp.DeletedAt.Before(deletedBefore) {
+				continue
+			}
+			if len(tx.data.idx.repliesTo[id]) > 0 || len(tx.data.idx.quotesOf[id]) > 0 {
+				continue
+			}
+			for _, rechirpID := range tx.data.idx.rechirpsOf[id] {
+				tx.removeChirp(rechirpID)
+				n++
+			}
+			tx.removeChirpLikes(id)
+			tx.removeChirp(id)
+			n++
+		}
+		if n == 0 {
+			return purged, nil
+		}
+		purged += n
+	}
+}
+
+// SortChirps sorts chirps in place the way listings are sorted.
+func SortChirps(chirps []Chirp, by SortKey, order SortOrder) {
+	sort.Slice(chirps, func(i, j int) bool {
+		a, b := chirps[i], chirps[j]
+		if order == SortDesc {
+			a, b = b, a
+		}
+		switch by {
+		case SortByCreatedAt:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		case SortByUpdatedAt:
+			if !a.UpdatedAt.Equal(b.UpdatedAt) {
+				return a.UpdatedAt.Before(b.UpdatedAt)
+			}
+		}
+		return a.ID < b.ID
+	})
+}
@@ -3,86 +3,422 @@ package database
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var ErrNotExist = errors.New("resource does not exist")
 
+// DB is a Store that keeps its data in memory and persists it to a JSON
+// file, or nowhere when created with NewMemDB.
 type DB struct {
 	path string
 	mu   *sync.RWMutex
+	data *DBStructure
+	keys *Keyring
+	lock *os.File
+
+	events *eventHub
+
+	// epoch, recent and committed serve the log to followers; see
+	// replication.go.
+	epoch     string
+	recent    []LogEntry
+	committed chan struct{}
+
+	readOnly       bool
+	reloadInterval time.Duration
+
+	flushInterval time.Duration
+	flushMu       *sync.Mutex
+	dirty         *atomic.Bool
+
+	wal              *walFile
+	compactThreshold int64
+	compact          chan struct{}
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Options tune how a DB persists its data.
+type Options struct {
+	// FlushInterval enables write-behind: instead of writing the file on
+	// every Update, changes are flushed at most this often. Changes made
+	// since the last flush are lost if the process dies. Zero writes
+	// synchronously.
+	FlushInterval time.Duration
+	// WAL appends each change to a write-ahead log instead of rewriting
+	// the whole file, and folds the log into the file in the background
+	// once it grows past CompactThreshold bytes. It can't be combined
+	// with FlushInterval.
+	WAL              bool
+	CompactThreshold int64
+	// Keys encrypts the database file, its backup and the write-ahead log
+	// with AES-GCM. A plaintext file is encrypted the first time it is
+	// opened with keys set.
+	Keys *Keyring
+	// ReadOnly opens the database without taking the write lock, so it can
+	// be shared with the process that has it open for writing. Changes
+	// made by that process are picked up every ReloadInterval (default one
+	// second). Writes fail with ErrReadOnly.
+	ReadOnly       bool
+	ReloadInterval time.Duration
 }
 
 type DBStructure struct {
+	SchemaVersion int                     `json:"schema_version"`
 	Chirps        map[int]Chirp           `json:"chirps"`
 	Users         map[int]User            `json:"users"`
 	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	Likes         map[int]Like            `json:"likes"`
+	Sequences     Sequences               `json:"sequences"`
+	// LogSeq is the last transaction included in this snapshot. It
+	// numbers write-ahead log entries and the entries sent to followers.
+	LogSeq uint64 `json:"log_seq"`
+	// Events holds the most recent change events, oldest first, and
+	// EventSeq the Seq of the last one handed out.
+	Events   []Event `json:"events,omitempty"`
+	EventSeq uint64  `json:"event_seq"`
+
+	idx *indexes
+}
+
+// Sequences holds the last ID handed out for each collection. IDs are
+// never reused, even after the record they belonged to is deleted.
+type Sequences struct {
+	Chirps int `json:"chirps"`
+	Users  int `json:"users"`
+	Likes  int `json:"likes"`
 }
 
 func NewDB(path string) (*DB, error) {
-	db := &DB{
-		path: path,
-		mu:   &sync.RWMutex{},
+	return NewDBWithOptions(path, Options{})
+}
+
+func NewDBWithOptions(path string, opts Options) (*DB, error) {
+	if opts.WAL && opts.FlushInterval > 0 {
+		return nil, errors.New("write-ahead logging can't be combined with a flush interval")
+	}
+	if opts.ReadOnly && (opts.WAL || opts.FlushInterval > 0) {
+		return nil, errors.New("a read-only database can't use write-ahead logging or a flush interval")
+	}
+	db := newDB(path, opts)
+
+	if db.readOnly {
+		state, err := db.statFiles()
+		if err != nil {
+			return nil, err
+		}
+		dbStructure, err := db.loadReadOnly()
+		if err != nil {
+			return nil, err
+		}
+		db.data = &dbStructure
+		go db.reloadLoop(state)
+		return db, nil
+	}
+
+	lock, err := lockFile(db.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", db.path, err)
+	}
+	db.lock = lock
+	err = db.ensureDB()
+	if err != nil {
+		db.lock.Close()
+		return nil, err
+	}
+
+	switch {
+	case opts.WAL:
+		err := db.openLog()
+		if err != nil {
+			db.lock.Close()
+			return nil, err
+		}
+		go db.compactLoop()
+	case db.flushInterval > 0:
+		go db.flushLoop()
+	default:
+		close(db.stopped)
+	}
+	return db, nil
+}
+
+// NewMemDB returns a database that is never written to disk. Everything is
+// lost when the process exits.
+func NewMemDB() *DB {
+	db := newDB("", Options{})
+	db.ensureDB()
+	close(db.stopped)
+	return db
+}
+
+func newDB(path string, opts Options) *DB {
+	compactThreshold := opts.CompactThreshold
+	if compactThreshold <= 0 {
+		compactThreshold = defaultCompactThreshold
+	}
+	reloadInterval := opts.ReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultReloadInterval
+	}
+	return &DB{
+		path:             path,
+		mu:               &sync.RWMutex{},
+		keys:             opts.Keys,
+		events:           newEventHub(),
+		epoch:            newEpoch(),
+		committed:        make(chan struct{}),
+		readOnly:         opts.ReadOnly,
+		reloadInterval:   reloadInterval,
+		flushInterval:    opts.FlushInterval,
+		flushMu:          &sync.Mutex{},
+		dirty:            &atomic.Bool{},
+		compactThreshold: compactThreshold,
+		compact:          make(chan struct{}, 1),
+		stop:             make(chan struct{}),
+		stopped:          make(chan struct{}),
 	}
-	err := db.ensureDB()
-	return db, err
 }
 
-func (db *DB) createDB() error {
-	dbStructure := DBStructure{
+func newDBStructure() DBStructure {
+	return DBStructure{
+		SchemaVersion: latestSchemaVersion(),
 		Chirps:        map[int]Chirp{},
 		Users:         map[int]User{},
 		RefreshTokens: map[string]RefreshToken{},
+		Likes:         map[int]Like{},
 	}
-	return db.writeDB(dbStructure)
 }
 
+func (db *DB) inMemory() bool {
+	return db.path == ""
+}
+
+// ensureDB loads the database file into memory, creating, recovering or
+// migrating it as needed.
 func (db *DB) ensureDB() error {
-	_, err := os.ReadFile(db.path)
+	if db.inMemory() {
+		dbStructure := newDBStructure()
+		dbStructure.buildIndexes()
+		db.data = &dbStructure
+		return nil
+	}
+
+	dbStructure, keyID, err := readDBFile(db.path, db.keys)
 	if errors.Is(err, os.ErrNotExist) {
-		return db.createDB()
+		_, statErr := os.Stat(db.backupPath())
+		if errors.Is(statErr, os.ErrNotExist) {
+			dbStructure = newDBStructure()
+			if db.keys != nil {
+				keyID = db.keys.ActiveKeyID()
+			}
+			err = db.writeDB(dbStructure)
+		} else {
+			log.Printf("%s is missing, recovering from %s", db.path, db.backupPath())
+			dbStructure, keyID, err = db.recoverDB()
+		}
+	} else if errors.Is(err, ErrMissingKey) {
+		return fmt.Errorf("%s: %w", db.path, err)
+	} else if err != nil {
+		log.Printf("%s is corrupt (%v), recovering from %s", db.path, err, db.backupPath())
+		dbStructure, keyID, err = db.recoverDB()
 	}
-	return err
+	if err != nil {
+		return err
+	}
+
+	err = db.migrateDB(&dbStructure)
+	if err != nil {
+		return err
+	}
+	dbStructure.buildIndexes()
+
+	applied, err := db.replayLog(&dbStructure)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		log.Printf("Replayed %d records from %s", applied, db.walPath())
+		err := db.writeDB(dbStructure)
+		if err != nil {
+			return err
+		}
+		err = os.Truncate(db.walPath(), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.keys != nil && keyID == "" {
+		err := db.rewriteAll(dbStructure)
+		if err != nil {
+			return fmt.Errorf("couldn't encrypt %s: %w", db.path, err)
+		}
+		log.Printf("Encrypted %s with key %q", db.path, db.keys.ActiveKeyID())
+	}
+
+	db.data = &dbStructure
+	return nil
+}
+
+// recoverDB moves a damaged database file aside and replaces it with the
+// last good generation kept in the .bak file.
+func (db *DB) recoverDB() (DBStructure, string, error) {
+	dbStructure, keyID, err := readDBFile(db.backupPath(), db.keys)
+	if err != nil {
+		return DBStructure{}, "", fmt.Errorf("couldn't recover %s: backup is unusable: %w", db.path, err)
+	}
+
+	corruptPath := fmt.Sprintf("%s.corrupt-%s", db.path, time.Now().UTC().Format("20060102T150405"))
+	err = os.Rename(db.path, corruptPath)
+	if err == nil {
+		log.Printf("Moved damaged database to %s", corruptPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return DBStructure{}, "", err
+	}
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return DBStructure{}, "", err
+	}
+	log.Printf("Recovered %s from %s (%d users, %d chirps, %d refresh tokens)",
+		db.path, db.backupPath(),
+		len(dbStructure.Users), len(dbStructure.Chirps), len(dbStructure.RefreshTokens),
+	)
+	return dbStructure, keyID, nil
+}
+
+func (db *DB) backupPath() string {
+	return db.path + ".bak"
 }
 
 func (db *DB) ResetDB() error {
-	err := os.Remove(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return nil
+	if db.readOnly {
+		return ErrReadOnly
 	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.inMemory() {
+		for _, path := range []string{db.path, db.backupPath()} {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		err := os.Truncate(db.walPath(), 0)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if db.wal != nil {
+			db.wal.size = 0
+		}
+	}
+	db.dirty.Store(false)
+	db.resetLog()
 	return db.ensureDB()
 }
 
-func (db *DB) loadDB() (DBStructure, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
+// readDBFile reads a database file, decrypting it with keys if needed. It
+// also returns the ID of the key the file was encrypted with, or an empty
+// string if it wasn't.
+func readDBFile(path string, keys *Keyring) (DBStructure, string, error) {
 	dbStructure := DBStructure{}
-	dat, err := os.ReadFile(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return dbStructure, err
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return dbStructure, "", err
+	}
+	dat, keyID, err := keys.open(dat)
+	if err != nil {
+		return dbStructure, keyID, err
 	}
 	err = json.Unmarshal(dat, &dbStructure)
 	if err != nil {
-		return dbStructure, err
+		return dbStructure, keyID, err
 	}
-
-	return dbStructure, nil
+	return dbStructure, keyID, nil
 }
 
+// writeDB writes dbStructure to the database file. Callers must hold db.mu
+// so that it can't change underneath.
 func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	if db.inMemory() {
+		return nil
+	}
 
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
-
-	err = os.WriteFile(db.path, dat, 0600)
+	dat, err = db.keys.seal(dat)
 	if err != nil {
 		return err
 	}
-	return nil
-}
\ No newline at end of file
+
+	db.flushMu.Lock()
+	defer db.flushMu.Unlock()
+	return writeFileAtomic(db.path, db.backupPath(), dat)
+}
+
+// Flush writes out changes that are waiting for the write-behind flusher.
+func (db *DB) Flush() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if !db.dirty.Swap(false) {
+		return nil
+	}
+	err := db.writeDB(*db.data)
+	if err != nil {
+		db.dirty.Store(true)
+	}
+	return err
+}
+
+func (db *DB) flushLoop() {
+	defer close(db.stopped)
+
+	ticker := time.NewTicker(db.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := db.Flush()
+			if err != nil {
+				log.Printf("Couldn't flush %s: %s", db.path, err)
+			}
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background flusher, compactor or reloader, writes any
+// pending changes and releases the write lock.
+func (db *DB) Close() error {
+	select {
+	case <-db.stop:
+		return nil
+	default:
+	}
+	close(db.stop)
+	<-db.stopped
+
+	var err error
+	if db.wal != nil {
+		err = db.wal.f.Close()
+	} else {
+		err = db.Flush()
+	}
+	if db.lock != nil {
+		db.lock.Close()
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const benchmarkChirps = 1000
+
+// newBenchmarkDB returns the path of a database file holding
+// benchmarkChirps chirps, and the database opened on it.
+func newBenchmarkDB(b *testing.B) (string, *DB) {
+	b.Helper()
+	path := filepath.Join(b.TempDir(), "database.json")
+	db, err := NewDBWithOptions(path, Options{FlushInterval: time.Hour})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchmarkChirps; i++ {
+		_, err := db.CreateChirp(fmt.Sprintf("chirp number %d", i), false, i%50+1, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	db, err = NewDB(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return path, db
+}
+
+// The reread benchmarks do what every read did before reads were served
+// from memory: load and decode the whole file.
+
+func BenchmarkGetChirp(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		_, db := newBenchmarkDB(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := db.GetChirp(i%benchmarkChirps + 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reread", func(b *testing.B) {
+		path, _ := newBenchmarkDB(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			data, _, err := readDBFile(path, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, ok := data.Chirps[i%benchmarkChirps+1]; !ok {
+				b.Fatal("chirp is missing")
+			}
+		}
+	})
+}
+
+func BenchmarkGetChirps(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		_, db := newBenchmarkDB(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := db.GetChirps(SortByID, SortAsc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reread", func(b *testing.B) {
+		path, _ := newBenchmarkDB(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			data, _, err := readDBFile(path, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			chirps := make([]Chirp, 0, len(data.Chirps))
+			for _, chirp := range data.Chirps {
+				chirps = append(chirps, chirp)
+			}
+			SortChirps(chirps, SortByID, SortAsc)
+		}
+	})
+}
+
+func BenchmarkCreateChirp(b *testing.B) {
+	modes := []struct {
+		name string
+		opts Options
+	}{
+		{"sync", Options{}},
+		{"flush interval", Options{FlushInterval: 100 * time.Millisecond}},
+		{"wal", Options{WAL: true}},
+	}
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			db, err := NewDBWithOptions(filepath.Join(b.TempDir(), "database.json"), mode.opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.CreateChirp("a chirp", false, 1, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
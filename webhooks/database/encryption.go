@@ -0,0 +1,207 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const encryptedFormat = "chirpy-encrypted"
+
+// ErrMissingKey means a file is encrypted with a key that isn't in the
+// keyring, or that no keyring was configured at all.
+var ErrMissingKey = errors.New("missing decryption key")
+
+// Keyring holds the AES-256 keys a DB encrypts its files with. Files are
+// always written with the active key; the others are kept so that files
+// written before a key rotation can still be read until they are rekeyed.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// encryptedFile wraps the contents of an encrypted file. Each WAL record is
+// wrapped separately, one per line.
+type encryptedFile struct {
+	Format     string `json:"format"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// ParseKeyring reads keys in the form "id:base64key", separated by commas
+// or newlines. Blank lines and lines starting with # are ignored. The first
+// key is the active one. Keys must be 32 bytes, e.g. from
+// `openssl rand -base64 32`.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("key %q: expected id:base64key", line)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("key %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q is %d bytes, want 32", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+		if k.active == "" {
+			k.active = id
+		}
+	}
+	if k.active == "" {
+		return nil, errors.New("no keys given")
+	}
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the key new files are encrypted with.
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// seal encrypts plaintext with the active key. A nil keyring leaves it as
+// it is.
+func (k *Keyring) seal(plaintext []byte) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	aead := k.keys[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encryptedFile{
+		Format:     encryptedFormat,
+		KeyID:      k.active,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, additionalData(k.active)),
+	})
+}
+
+// open decrypts dat if it was written by seal and returns it unchanged
+// otherwise. keyID is the key it was encrypted with, or empty for plain
+// data.
+func (k *Keyring) open(dat []byte) (plaintext []byte, keyID string, err error) {
+	if !isEncrypted(dat) {
+		return dat, "", nil
+	}
+	file := encryptedFile{}
+	err = json.Unmarshal(dat, &file)
+	if err != nil {
+		return nil, "", err
+	}
+	if k == nil {
+		return nil, file.KeyID, ErrMissingKey
+	}
+	aead, ok := k.keys[file.KeyID]
+	if !ok {
+		return nil, file.KeyID, fmt.Errorf("%w: file is encrypted with key %q", ErrMissingKey, file.KeyID)
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, file.KeyID, errors.New("encrypted file has a malformed nonce")
+	}
+	plaintext, err = aead.Open(nil, file.Nonce, file.Ciphertext, additionalData(file.KeyID))
+	if err != nil {
+		return nil, file.KeyID, fmt.Errorf("couldn't decrypt with key %q: %w", file.KeyID, err)
+	}
+	return plaintext, file.KeyID, nil
+}
+
+// isEncrypted reports whether dat looks like the output of seal. Plain
+// database files start with schema_version, so the leading field is enough
+// to tell them apart.
+func isEncrypted(dat []byte) bool {
+	return bytes.HasPrefix(dat, []byte(`{"format":"`+encryptedFormat+`"`))
+}
+
+// additionalData binds the key ID into the ciphertext, so that the header
+// can't be swapped to point at another key.
+func additionalData(keyID string) []byte {
+	return []byte(encryptedFormat + ":" + keyID)
+}
+
+// Rekey re-encrypts the database file and its backup with the active key
+// and folds the write-ahead log into the file, so that nothing on disk
+// depends on older keys any more. Schema migration backups are left as
+// they are.
+func (db *DB) Rekey() error {
+	if db.keys == nil {
+		return errors.New("no encryption keys configured")
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if db.inMemory() {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	err := db.rewriteAll(*db.data)
+	if err != nil {
+		return err
+	}
+	db.dirty.Store(false)
+	log.Printf("Rekeyed %s with key %q", db.path, db.keys.ActiveKeyID())
+	return nil
+}
+
+// rewriteAll replaces the database file and its backup with dbStructure
+// and empties the write-ahead log. Callers must hold db.mu.
+func (db *DB) rewriteAll(dbStructure DBStructure) error {
+	err := db.writeDB(dbStructure)
+	if err != nil {
+		return err
+	}
+	dat, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(db.backupPath(), "", dat)
+	if err != nil {
+		return err
+	}
+
+	if db.wal != nil {
+		err := db.wal.f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		db.wal.size = 0
+		return nil
+	}
+	err = os.Truncate(db.walPath(), 0)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxRetainedEvents is how many of the most recent events a store keeps for
+// subscribers resuming from an earlier position.
+const maxRetainedEvents = 10000
+
+// subscriberBuffer is how many events a subscriber can fall behind by
+// before it is dropped.
+const subscriberBuffer = 1024
+
+var (
+	// ErrEventsTruncated means a subscriber asked to resume from a position
+	// older than the oldest event the store still has.
+	ErrEventsTruncated = errors.New("events after that position are no longer retained")
+	// ErrSubscriberTooSlow means a subscription was closed because its
+	// subscriber didn't keep up. It can resume from the last event it saw.
+	ErrSubscriberTooSlow = errors.New("subscriber fell too far behind")
+)
+
+// Change is the payload of an Event: one of ChirpCreated, ChirpEdited,
+// ChirpDeleted, ChirpUndeleted, ChirpLiked, ChirpUnliked, UserUpdated,
+// UserUpgraded or TokenRevoked.
+//
+// Bulk operations emit no events: ResetDB, Restore, Import (including
+// NDJSON imports), PurgeDeletedChirps and PurgeExpiredRefreshTokens. A
+// subscriber that keeps its own copy of the data must treat them like
+// ErrEventsTruncated: reload from a snapshot, then subscribe from
+// LastEventSeq.
+type Change interface {
+	eventType() string
+}
+
+type ChirpCreated struct {
+	Chirp Chirp `json:"chirp"`
+}
+
+type ChirpEdited struct {
+	ChirpID  int    `json:"chirp_id"`
+	AuthorID int    `json:"author_id"`
+	Body     string `json:"body"`
+}
+
+type ChirpDeleted struct {
+	ChirpID   int `json:"chirp_id"`
+	AuthorID  int `json:"author_id"`
+	DeletedBy int `json:"deleted_by"`
+}
+
+type ChirpUndeleted struct {
+	Chirp Chirp `json:"chirp"`
+}
+
+type ChirpLiked struct {
+	ChirpID int `json:"chirp_id"`
+	UserID  int `json:"user_id"`
+}
+
+type ChirpUnliked struct {
+	ChirpID int `json:"chirp_id"`
+	UserID  int `json:"user_id"`
+}
+
+type UserUpdated struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+type UserUpgraded struct {
+	UserID int `json:"user_id"`
+}
+
+type TokenRevoked struct {
+	UserID int `json:"user_id"`
+}
+
+func (ChirpCreated) eventType() string   { return "chirp_created" }
+func (ChirpEdited) eventType() string    { return "chirp_edited" }
+func (ChirpDeleted) eventType() string   { return "chirp_deleted" }
+func (ChirpUndeleted) eventType() string { return "chirp_undeleted" }
+func (ChirpLiked) eventType() string     { return "chirp_liked" }
+func (ChirpUnliked) eventType() string   { return "chirp_unliked" }
+func (UserUpdated) eventType() string    { return "user_updated" }
+func (UserUpgraded) eventType() string   { return "user_upgraded" }
+func (TokenRevoked) eventType() string   { return "token_revoked" }
+
+// Event is a change committed to the store. Seq numbers start at 1 and go
+// up by one for every event, so a subscriber that remembers the last Seq
+// it handled can resume from there.
+type Event struct {
+	Seq    uint64
+	Time   time.Time
+	Change Change
+}
+
+type eventJSON struct {
+	Seq  uint64          `json:"seq"`
+	Time time.Time       `json:"time"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(e.Change)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(eventJSON{
+		Seq:  e.Seq,
+		Time: e.Time,
+		Type: e.Change.eventType(),
+		Data: data,
+	})
+}
+
+func (e *Event) UnmarshalJSON(dat []byte) error {
+	raw := eventJSON{}
+	err := json.Unmarshal(dat, &raw)
+	if err != nil {
+		return err
+	}
+	change, err := decodeChange(raw.Type, raw.Data)
+	if err != nil {
+		return err
+	}
+	*e = Event{Seq: raw.Seq, Time: raw.Time, Change: change}
+	return nil
+}
+
+func decodeChange(eventType string, data []byte) (Change, error) {
+	var change Change
+	switch eventType {
+	case ChirpCreated{}.eventType():
+		change = &ChirpCreated{}
+	case ChirpEdited{}.eventType():
+		change = &ChirpEdited{}
+	case ChirpDeleted{}.eventType():
+		change = &ChirpDeleted{}
+	case ChirpUndeleted{}.eventType():
+		change = &ChirpUndeleted{}
+	case ChirpLiked{}.eventType():
+		change = &ChirpLiked{}
+	case ChirpUnliked{}.eventType():
+		change = &ChirpUnliked{}
+	case UserUpdated{}.eventType():
+		change = &UserUpdated{}
+	case UserUpgraded{}.eventType():
+		change = &UserUpgraded{}
+	case TokenRevoked{}.eventType():
+		change = &TokenRevoked{}
+	default:
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	err := json.Unmarshal(data, change)
+	if err != nil {
+		return nil, err
+	}
+	// Hand out values, not pointers, so type switches match what was
+	// emitted.
+	switch c := change.(type) {
+	case *ChirpCreated:
+		return *c, nil
+	case *ChirpEdited:
+		return *c, nil
+	case *ChirpDeleted:
+		return *c, nil
+	case *ChirpUndeleted:
+		return *c, nil
+	case *ChirpLiked:
+		return *c, nil
+	case *ChirpUnliked:
+		return *c, nil
+	case *UserUpdated:
+		return *c, nil
+	case *UserUpgraded:
+		return *c, nil
+	case *TokenRevoked:
+		return *c, nil
+	}
+	panic("unreachable")
+}
+
+// Subscription delivers events to one subscriber on C, in Seq order. C is
+// closed when the subscription is closed, after which Err says why.
+type Subscription struct {
+	C <-chan Event
+
+	c   chan Event
+	hub *eventHub
+	err error
+}
+
+// Close stops delivery. Events already buffered in C are discarded.
+func (s *Subscription) Close() {
+	s.hub.remove(s, nil)
+}
+
+// Err returns ErrSubscriberTooSlow if the subscription was dropped because
+// C filled up, and nil otherwise.
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// eventHub fans committed events out to subscribers. Stores must publish
+// events in Seq order, and must not publish while a subscribe for the same
+// store is collecting its backlog.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: map[*Subscription]struct{}{}}
+}
+
+// subscribe registers a subscriber and queues backlog for it.
+func (h *eventHub) subscribe(backlog []Event) *Subscription {
+	c := make(chan Event, len(backlog)+subscriberBuffer)
+	for _, e := range backlog {
+		c <- e
+	}
+	sub := &Subscription{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) publish(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		for _, e := range events {
+			select {
+			case sub.c <- e:
+				continue
+			default:
+			}
+			h.removeLocked(sub, ErrSubscriberTooSlow)
+			break
+		}
+	}
+}
+
+func (h *eventHub) remove(sub *Subscription, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(sub, err)
+}
+
+func (h *eventHub) removeLocked(sub *Subscription, err error) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	sub.err = err
+	close(sub.c)
+}
+
+// eventsAfter returns the retained events with a Seq greater than after.
+// events must be sorted by Seq and lastSeq is the newest Seq handed out.
+func eventsAfter(events []Event, lastSeq, after uint64) ([]Event, error) {
+	if after > lastSeq {
+		return nil, fmt.Errorf("position %d is ahead of the last event %d", after, lastSeq)
+	}
+	if after == lastSeq {
+		return nil, nil
+	}
+	if len(events) == 0 || events[0].Seq > after+1 {
+		return nil, ErrEventsTruncated
+	}
+	i := int(after + 1 - events[0].Seq)
+	return append([]Event(nil), events[i:]...), nil
+}
+
+// Subscribe delivers every event committed after the one numbered after,
+// starting with those already retained. Pass zero to start from the
+// oldest retained event, or LastEventSeq to only get new ones.
+func (db *DB) Subscribe(after uint64) (*Subscription, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if after == 0 {
+		after = db.data.EventSeq - uint64(len(db.data.Events))
+	}
+	backlog, err := eventsAfter(db.data.Events, db.data.EventSeq, after)
+	if err != nil {
+		return nil, err
+	}
+	return db.events.subscribe(backlog), nil
+}
+
+// LastEventSeq returns the Seq of the newest event.
+func (db *DB) LastEventSeq() (uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.data.EventSeq, nil
+}
+
+// emit records change as an event to be published once the transaction
+// commits.
+func (tx *Tx) emit(change Change) {
+	e := Event{
+		Seq:    tx.data.EventSeq + 1,
+		Time:   time.Now().UTC(),
+		Change: change,
+	}
+	tx.appendEvent(e)
+	tx.events = append(tx.events, e)
+}
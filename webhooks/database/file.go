@@ -0,0 +1,78 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temp file next to path, fsyncs it and
+// renames it over path, so readers only ever see the old or the new
+// contents. If backupPath is set, the previous file is kept there too.
+func writeFileAtomic(path, backupPath string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Chmod(0600)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if backupPath != "" {
+		err = keepBackup(path, backupPath)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(tmpName, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// keepBackup makes backupPath a copy of path without ever moving path out
+// of the way. A hard link is used where the filesystem allows it.
+func keepBackup(path, backupPath string) error {
+	err := os.Remove(backupPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Link(path, backupPath)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(backupPath, "", data)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FsckProblem is one integrity problem found by Fsck.
+type FsckProblem struct {
+	Collection string `json:"collection"`
+	Key        string `json:"key"`
+	Problem    string `json:"problem"`
+	// Repaired is set if Fsck was asked to repair and managed to. Some
+	// problems, such as duplicate emails, always need a person to decide.
+	Repaired bool `json:"repaired"`
+}
+
+// FsckReport is the outcome of Fsck: how many records were checked and
+// what was wrong with them.
+type FsckReport struct {
+	Users         int           `json:"users"`
+	Chirps        int           `json:"chirps"`
+	RefreshTokens int           `json:"refresh_tokens"`
+	Likes         int           `json:"likes"`
+	Problems      []FsckProblem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems that are still there.
+func (r FsckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckReport) add(collection, key, format string, args ...any) *FsckProblem {
+	r.Problems = append(r.Problems, FsckProblem{
+		Collection: collection,
+		Key:        key,
+		Problem:    fmt.Sprintf(format, args...),
+	})
+	return &r.Problems[len(r.Problems)-1]
+}
+
+// Fsck checks that every chirp, refresh token and like belongs to an
+// existing user, that replies, rechirps, quotes and likes are of existing
+// chirps, that records are stored under their own ID, that emails are
+// unique, that no user likes a chirp twice and that the ID sequences are
+// ahead of every ID in use. With repair, it also fixes what it can:
+// orphaned records and duplicate likes are deleted, replies and quotes of
+// missing chirps are made into chirps of their own, records are given the
+// ID they are stored under and sequences are moved forward.
+func (db *DB) Fsck(repair bool) (FsckReport, error) {
+	var report FsckReport
+	fn := func(tx *Tx) error {
+		var err error
+		report, err = tx.Fsck(repair)
+		return err
+	}
+	if repair {
+		return report, db.Update(fn)
+	}
+	return report, db.View(fn)
+}
+
+func (tx *Tx) Fsck(repair bool) (FsckReport, error) {
+	if repair {
+		if err := tx.checkWritable(); err != nil {
+			return FsckReport{}, err
+		}
+	}
+	data := tx.data
+	report := FsckReport{
+		Users:         len(data.Users),
+		Chirps:        len(data.Chirps),
+		RefreshTokens: len(data.RefreshTokens),
+		Likes:         len(data.Likes),
+		Problems:      []FsckProblem{},
+	}
+
+	for _, id := range sortedKeys(data.Users) {
+		user := data.Users[id]
+		if user.ID == id {
+			continue
+		}
+		p := report.add(CollectionUsers, strconv.Itoa(id), "stored under %d but has ID %d", id, user.ID)
+		if repair {
+			user.ID = id
+			if tx.putUser(user) == nil {
+				p.Repaired = true
+			}
+		}
+	}
+
+	byEmail := map[string][]int{}
+	for _, id := range sortedKeys(data.Users) {
+		email := data.Users[id].Email
+		byEmail[email] = append(byEmail[email], id)
+	}
+	emails := make([]string, 0, len(byEmail))
+	for email, ids := range byEmail {
+		if len(ids) > 1 {
+			emails = append(emails, email)
+		}
+	}
+	sort.Strings(emails)
+	for _, email := range emails {
+		ids := byEmail[email]
+		for _, id := range ids[1:] {
+			report.add(CollectionUsers, strconv.Itoa(id), "email %q is also used by user %d", email, ids[0])
+		}
+	}
+
+	for _, id := range sortedKeys(data.Chirps) {
+		chirp := data.Chirps[id]
+		if _, ok := data.Users[chirp.AuthorID]; !ok {
+			p := report.add(CollectionChirps, strconv.Itoa(id), "author %d doesn't exist", chirp.AuthorID)
+			if repair {
+				tx.removeChirp(id)
+				p.Repaired = true
+			}
+			continue
+		}
+		if chirp.ID != id {
+			p := report.add(CollectionChirps, strconv.Itoa(id), "stored under %d but has ID %d", id, chirp.ID)
+			if repair {
+				chirp.ID = id
+				tx.putChirp(chirp)
+				p.Repaired = true
+			}
+		}
+	}
+
+	// Checked after orphaned chirps have been removed, in case a reply's
+	// parent or what a chirp shares or quotes was one of them.
+	for _, id := range sortedKeys(data.Chirps) {
+		chirp := data.Chirps[id]
+		if _, ok := data.Chirps[chirp.RechirpOf]; chirp.RechirpOf != 0 && !ok {
+			// A rechirp is nothing without what it shares.
+			p := report.add(CollectionChirps, strconv.Itoa(id), "rechirps chirp %d, which doesn't exist", chirp.RechirpOf)
+			if repair {
+				tx.removeChirp(id)
+				p.Repaired = true
+			}
+			continue
+		}
+		changed := false
+		if _, ok := data.Chirps[chirp.InReplyTo]; chirp.InReplyTo != 0 && !ok {
+			p := report.add(CollectionChirps, strconv.Itoa(id), "replies to chirp %d, which doesn't exist", chirp.InReplyTo)
+			if repair {
+				chirp.InReplyTo = 0
+				changed = true
+				p.Repaired = true
+			}
+		}
+		if _, ok := data.Chirps[chirp.QuoteOf]; chirp.QuoteOf != 0 && !ok {
+			p := report.add(CollectionChirps, strconv.Itoa(id), "quotes chirp %d, which doesn't exist", chirp.QuoteOf)
+			if repair {
+				chirp.QuoteOf = 0
+				changed = true
+				p.Repaired = true
+			}
+		}
+		if changed {
+			tx.putChirp(chirp)
+		}
+	}
+
+	likes := map[likeKey]int{}
+	for _, id := range sortedKeys(data.Likes) {
+		like := data.Likes[id]
+		if _, ok := data.Users[like.UserID]; !ok {
+			p := report.add(CollectionLikes, strconv.Itoa(id), "user %d doesn't exist", like.UserID)
+			if repair {
+				tx.removeLike(id)
+				p.Repaired = true
+			}
+			continue
+		}
+		if _, ok := data.Chirps[like.ChirpID]; !ok {
+			p := report.add(CollectionLikes, strconv.Itoa(id), "chirp %d doesn't exist", like.ChirpID)
+			if repair {
+				tx.removeLike(id)
+				p.Repaired = true
+			}
+			continue
+		}
+		key := likeKey{like.ChirpID, like.UserID}
+		if other, ok := likes[key]; ok {
+			p := report.add(CollectionLikes, strconv.Itoa(id), "user %d already likes chirp %d in like %d", like.UserID, like.ChirpID, other)
+			if repair {
+				tx.removeLike(id)
+				p.Repaired = true
+			}
+			continue
+		}
+		likes[key] = id
+		if like.ID != id {
+			p := report.add(CollectionLikes, strconv.Itoa(id), "stored under %d but has ID %d", id, like.ID)
+			if repair {
+				like.ID = id
+				tx.putLike(like)
+				p.Repaired = true
+			}
+		}
+	}
+
+	tokens := make([]string, 0, len(data.RefreshTokens))
+	for token := range data.RefreshTokens {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	for _, token := range tokens {
+		refreshToken := data.RefreshTokens[token]
+		// Tokens are secrets, so only a prefix goes in the report.
+		key := token[:min(len(token), 8)] + "…"
+		if _, ok := data.Users[refreshToken.UserID]; !ok {
+			p := report.add(CollectionRefreshTokens, key, "user %d doesn't exist", refreshToken.UserID)
+			if repair {
+				tx.removeRefreshToken(token)
+				p.Repaired = true
+			}
+			continue
+		}
+		if refreshToken.Token != token {
+			p := report.add(CollectionRefreshTokens, key, "stored under a different token than it holds")
+			if repair {
+				refreshToken.Token = token
+				tx.putRefreshToken(refreshToken)
+				p.Repaired = true
+			}
+		}
+	}
+
+	for _, seq := range []struct {
+		name    string
+		current int
+		max     int
+	}{
+		{"chirps", data.Sequences.Chirps, maxKey(data.Chirps)},
+		{"users", data.Sequences.Users, maxKey(data.Users)},
+		{"likes", data.Sequences.Likes, maxKey(data.Likes)},
+	} {
+		if seq.current >= seq.max {
+			continue
+		}
+		p := report.add("sequences", seq.name, "at %d but IDs up to %d are in use", seq.current, seq.max)
+		if repair {
+			tx.setSequence(seq.name, seq.max)
+			p.Repaired = true
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidHashtag means a tag had no letters or had characters that
+// can't be part of a hashtag.
+var ErrInvalidHashtag = errors.New("not a valid hashtag")
+
+// trendingHalfLives is how many half-lives fit in a trending window: a use
+// of a tag at the start of the window counts a quarter as much as one now.
+const trendingHalfLives = 2
+
+// TrendingHashtag is a tag and how much it has been used lately. Chirps is
+// how many chirps in the window use it, and Score weighs each by how
+// recent it is.
+type TrendingHashtag struct {
+	Tag    string
+	Score  float64
+	Chirps int
+}
+
+// isHashtagRune reports whether r can be part of a hashtag: letters and
+// digits in any script, the marks that combine with them, and underscores.
+func isHashtagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || r == '_'
+}
+
+// isHashSign accepts the fullwidth number sign as well, as typed by CJK
+// input methods.
+func isHashSign(r rune) bool {
+	return r == '#' || r == '＃'
+}
+
+// foldHashtag gives every spelling of a tag the same form. NFKC makes
+// precomposed and decomposed accents, and fullwidth letters, the same
+// before case folding, and again after it in case folding undid that.
+// Upper-casing first makes variants like the Greek final sigma fold to the
+// same letter.
+func foldHashtag(tag string) string {
+	return norm.NFKC.String(strings.ToLower(strings.ToUpper(norm.NFKC.String(tag))))
+}
+
+// Hashtags returns the tags in body, case-folded and without the #, in the
+// order they first appear. A tag starts with # at the beginning of a word,
+// runs for as long as isHashtagRune allows and needs at least one letter,
+// so "#1" and "a#b" aren't tags.
+func Hashtags(body string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		if !isHashSign(runes[i]) || (i > 0 && (isHashtagRune(runes[i-1]) || isHashSign(runes[i-1]))) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isHashtagRune(runes[end]) {
+			end++
+		}
+		tag, ok := NormalizeHashtag(string(runes[i+1 : end]))
+		i = end - 1
+		if !ok || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// NormalizeHashtag case-folds tag, with or without its leading #, and
+// reports whether it is a valid hashtag.
+func NormalizeHashtag(tag string) (string, bool) {
+	if r := []rune(tag); len(r) > 0 && isHashSign(r[0]) {
+		tag = string(r[1:])
+	}
+	hasLetter := false
+	for _, r := range tag {
+		if !isHashtagRune(r) {
+			return "", false
+		}
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+	}
+	if !hasLetter {
+		return "", false
+	}
+	return foldHashtag(tag), true
+}
+
+// hashtagIndex maps tags to the chirps using them. Like searchIndex, it has
+// no locking of its own.
+type hashtagIndex struct {
+	// chirps maps each tag to the chirps using it and when each was posted.
+	chirps map[string]map[int]time.Time
+	// tags holds the tags of each indexed chirp, for removing it.
+	tags map[int][]string
+	// uses holds every use of a tag ordered by when it was posted, then
+	// by chirp ID, so trending only looks at the uses in its window.
+	uses []hashtagUse
+}
+
+// hashtagUse is one chirp's use of a tag.
+type hashtagUse struct {
+	at  time.Time
+	id  int
+	tag string
+}
+
+func newHashtagIndex() *hashtagIndex {
+	return &hashtagIndex{
+		chirps: map[string]map[int]time.Time{},
+		tags:   map[int][]string{},
+	}
+}
+
+// add indexes the tags of chirp, replacing what was indexed for its ID
+// before. Deleted chirps and rechirps are only removed.
+func (h *hashtagIndex) add(chirp Chirp) {
+	h.remove(chirp.ID)
+	uses := h.put(chirp)
+	if len(uses) == 0 {
+		return
+	}
+	i := h.useIndex(chirp.CreatedAt, chirp.ID)
+	h.uses = slices.Insert(h.uses, i, uses...)
+}
+
+// put records the tags of chirp, which mustn't be indexed yet, and returns
+// its uses for the caller to put in order in h.uses.
+func (h *hashtagIndex) put(chirp Chirp) []hashtagUse {
+	if chirp.Deleted() || chirp.RechirpOf != 0 {
+		return nil
+	}
+	tags := Hashtags(chirp.Body)
+	if len(tags) == 0 {
+		return nil
+	}
+	uses := make([]hashtagUse, len(tags))
+	for i, tag := range tags {
+		chirps, ok := h.chirps[tag]
+		if !ok {
+			chirps = map[int]time.Time{}
+			h.chirps[tag] = chirps
+		}
+		chirps[chirp.ID] = chirp.CreatedAt
+		uses[i] = hashtagUse{at: chirp.CreatedAt, id: chirp.ID, tag: tag}
+	}
+	h.tags[chirp.ID] = tags
+	return uses
+}
+
+func (h *hashtagIndex) remove(id int) {
+	tags := h.tags[id]
+	if len(tags) == 0 {
+		return
+	}
+	i := h.useIndex(h.chirps[tags[0]][id], id)
+	h.uses = slices.Delete(h.uses, i, i+len(tags))
+	for _, tag := range tags {
+		chirps := h.chirps[tag]
+		delete(chirps, id)
+		if len(chirps) == 0 {
+			delete(h.chirps, tag)
+		}
+	}
+	delete(h.tags, id)
+}
+
+// useIndex returns where the uses by the chirp with ID id, posted at at,
+// start in h.uses, or would go.
+func (h *hashtagIndex) useIndex(at time.Time, id int) int {
+	return sort.Search(len(h.uses), func(i int) bool {
+		u := h.uses[i]
+		return u.at.After(at) || (u.at.Equal(at) && u.id >= id)
+	})
+}
+
+// sortUses puts h.uses in order after chirps were put in bulk. The sort
+// is stable so each chirp's uses stay together.
+func (h *hashtagIndex) sortUses() {
+	slices.SortStableFunc(h.uses, func(a, b hashtagUse) int {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+		return a.id - b.id
+	})
+}
+
+// ids returns the IDs of the chirps using tag, which must be normalized.
+func (h *hashtagIndex) ids(tag string) []int {
+	ids := make([]int, 0, len(h.chirps[tag]))
+	for id := range h.chirps[tag] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// trending ranks the tags used in the window before now by their uses,
+// each weighted by exponential decay, and returns the top limit.
+func (h *hashtagIndex) trending(now time.Time, window time.Duration, limit int) []TrendingHashtag {
+	halfLife := window.Seconds() / trendingHalfLives
+	byTag := map[string]*TrendingHashtag{}
+	for _, u := range h.uses[h.useIndex(now.Add(-window), 0):] {
+		if u.at.After(now) {
+			break
+		}
+		t, ok := byTag[u.tag]
+		if !ok {
+			t = &TrendingHashtag{Tag: u.tag}
+			byTag[u.tag] = t
+		}
+		t.Score += math.Exp2(-now.Sub(u.at).Seconds() / halfLife)
+		t.Chirps++
+	}
+	trending := make([]TrendingHashtag, 0, len(byTag))
+	for _, t := range byTag {
+		trending = append(trending, *t)
+	}
+	sort.Slice(trending, func(i, j int) bool {
+		if trending[i].Score != trending[j].Score {
+			return trending[i].Score > trending[j].Score
+		}
+		return trending[i].Tag < trending[j].Tag
+	})
+	return trending[:min(len(trending), limit)]
+}
+
+func buildHashtagIndex(chirps map[int]Chirp) *hashtagIndex {
+	hashtags := newHashtagIndex()
+	for _, chirp := range chirps {
+		hashtags.uses = append(hashtags.uses, hashtags.put(chirp)...)
+	}
+	hashtags.sortUses()
+	return hashtags
+}
+
+// GetChirpsByHashtag returns the chirps using tag, which is normalized
+// first, sorted as given. It fails with ErrInvalidHashtag if tag can't be
+// a hashtag.
+func (db *DB) GetChirpsByHashtag(tag string, by SortKey, order SortOrder) ([]Chirp, error) {
+	tag, ok := NormalizeHashtag(tag)
+	if !ok {
+		return nil, ErrInvalidHashtag
+	}
+	var chirps []Chirp
+	err := db.View(func(tx *Tx) error {
+		chirps = []Chirp{}
+		for _, id := range tx.data.idx.hashtags.ids(tag) {
+			if chirp := tx.data.Chirps[id]; tx.visible(chirp) {
+				chirps = append(chirps, chirp)
+			}
+		}
+		SortChirps(chirps, by, order)
+		return nil
+	})
+	return chirps, err
+}
+
+// GetTrendingHashtags returns up to limit of the tags most used by chirps
+// posted in the last window, favouring recent uses.
+func (db *DB) GetTrendingHashtags(window time.Duration, limit int) ([]TrendingHashtag, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.data.idx.hashtags.trending(time.Now(), window, limit), nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import "sort"
+
+// indexes are derived from the collections in DBStructure. They are built
+// when the database is loaded and kept up to date by the Tx mutators, and
+// are never written to disk.
+type indexes struct {
+	// userByEmail maps each email to the ID of the user that owns it.
+	userByEmail map[string]int
+	// chirpsByAuthor maps an author to their chirp IDs in ascending order.
+	chirpsByAuthor map[int][]int
+	// repliesTo maps a chirp to the IDs of the chirps replying to it, in
+	// ascending order.
+	repliesTo map[int][]int
+	// rechirpsOf and quotesOf map a chirp to the IDs of the rechirps and
+	// quotes of it, in ascending order.
+	rechirpsOf map[int][]int
+	quotesOf   map[int][]int
+	// likesOf and likesBy map a chirp and a user to the IDs of their likes
+	// in ascending order, and likeByKey finds the like a user has given a
+	// chirp.
+	likesOf   map[int][]int
+	likesBy   map[int][]int
+	likeByKey map[likeKey]int
+	// search is the full-text index over chirp bodies, and hashtags the
+	// index of the tags in them.
+	search   *searchIndex
+	hashtags *hashtagIndex
+}
+
+func (data *DBStructure) buildIndexes() {
+	idx := &indexes{
+		userByEmail:    make(map[string]int, len(data.Users)),
+		chirpsByAuthor: map[int][]int{},
+		repliesTo:      map[int][]int{},
+		rechirpsOf:     map[int][]int{},
+		quotesOf:       map[int][]int{},
+		likesOf:        map[int][]int{},
+		likesBy:        map[int][]int{},
+		likeByKey:      make(map[likeKey]int, len(data.Likes)),
+	}
+	for _, id := range sortedKeys(data.Users) {
+		email := data.Users[id].Email
+		if _, ok := idx.userByEmail[email]; !ok {
+			idx.userByEmail[email] = id
+		}
+	}
+	for _, id := range sortedKeys(data.Chirps) {
+		chirp := data.Chirps[id]
+		idx.chirpsByAuthor[chirp.AuthorID] = append(idx.chirpsByAuthor[chirp.AuthorID], id)
+		if chirp.InReplyTo != 0 {
+			idx.repliesTo[chirp.InReplyTo] = append(idx.repliesTo[chirp.InReplyTo], id)
+		}
+		if chirp.RechirpOf != 0 {
+			idx.rechirpsOf[chirp.RechirpOf] = append(idx.rechirpsOf[chirp.RechirpOf], id)
+		}
+		if chirp.QuoteOf != 0 {
+			idx.quotesOf[chirp.QuoteOf] = append(idx.quotesOf[chirp.QuoteOf], id)
+		}
+	}
+	for _, id := range sortedKeys(data.Likes) {
+		like := data.Likes[id]
+		key := likeKey{like.ChirpID, like.UserID}
+		if _, ok := idx.likeByKey[key]; ok {
+			// A duplicate, for Fsck to report.
+			continue
+		}
+		idx.likeByKey[key] = id
+		idx.likesOf[like.ChirpID] = append(idx.likesOf[like.ChirpID], id)
+		idx.likesBy[like.UserID] = append(idx.likesBy[like.UserID], id)
+	}
+	idx.search = buildSearchIndex(data.Chirps)
+	idx.hashtags = buildHashtagIndex(data.Chirps)
+	data.idx = idx
+}
+
+// addToIndex and removeFromIndex add and remove id in the sorted list of
+// IDs stored under key, dropping the key once its list is empty.
+func addToIndex(tx *Tx, index map[int][]int, key, id int) {
+	put(tx, index, key, insertSorted(index[key], id))
+}
+
+func removeFromIndex(tx *Tx, index map[int][]int, key, id int) {
+	ids := removeSorted(index[key], id)
+	if len(ids) == 0 {
+		remove(tx, index, key)
+	} else {
+		put(tx, index, key, ids)
+	}
+}
+
+// moveInIndex moves id from under the key from to under the key to, where
+// zero means no key.
+func moveInIndex(tx *Tx, index map[int][]int, from, to, id int) {
+	if from == to {
+		return
+	}
+	if from != 0 {
+		removeFromIndex(tx, index, from, id)
+	}
+	if to != 0 {
+		addToIndex(tx, index, to, id)
+	}
+}
+
+// insertSorted and removeSorted return a new slice so that the old one can
+// be restored on rollback.
+func insertSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	out := make([]int, 0, len(ids)+1)
+	out = append(out, ids[:i]...)
+	out = append(out, id)
+	return append(out, ids[i:]...)
+}
+
+func removeSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	out := make([]int, 0, len(ids)-1)
+	out = append(out, ids[:i]...)
+	return append(out, ids[i+1:]...)
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Like records that a user likes a chirp. A user can like each chirp once.
+type Like struct {
+	ID        int       `json:"id"`
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikePage is one page of a listing of likes, newest first. Passing
+// NextBefore as before gets the next page; it is zero on the last one.
+type LikePage struct {
+	Likes      []Like
+	NextBefore int
+}
+
+// likeKey identifies a like by what was liked and who by.
+type likeKey struct {
+	chirpID int
+	userID  int
+}
+
+// LikeChirp records that userID likes the chirp with ID chirpID, unless
+// they already do, and returns the like. Liking a rechirp likes the chirp
+// it shares. It fails with ErrNotExist if the chirp doesn't exist.
+func (db *DB) LikeChirp(chirpID, userID int) (Like, error) {
+	var like Like
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		like, err = tx.LikeChirp(chirpID, userID)
+		return err
+	})
+	return like, err
+}
+
+// UnlikeChirp removes userID's like of the chirp with ID chirpID, if there
+// is one, and returns the ID of the chirp the like was on: the shared chirp
+// for a rechirp. It fails with ErrNotExist if the chirp doesn't exist.
+func (db *DB) UnlikeChirp(chirpID, userID int) (int, error) {
+	var likedID int
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		likedID, err = tx.UnlikeChirp(chirpID, userID)
+		return err
+	})
+	return likedID, err
+}
+
+func (db *DB) GetChirpLikes(chirpID, before, limit int) (LikePage, error) {
+	var page LikePage
+	err := db.View(func(tx *Tx) error {
+		var err error
+		page, err = tx.GetChirpLikes(chirpID, before, limit)
+		return err
+	})
+	return page, err
+}
+
+func (db *DB) GetUserLikes(userID, before, limit int) (LikePage, error) {
+	var page LikePage
+	err := db.View(func(tx *Tx) error {
+		var err error
+		page, err = tx.GetUserLikes(userID, before, limit)
+		return err
+	})
+	return page, err
+}
+
+func (tx *Tx) nextLikeID() int {
+	id := tx.data.Sequences.Likes + 1
+	tx.setSequence("likes", id)
+	return id
+}
+
+func (tx *Tx) LikeChirp(chirpID, userID int) (Like, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Like{}, err
+	}
+
+	chirp, err := tx.GetChirp(chirpID)
+	if err != nil {
+		return Like{}, err
+	}
+	chirpID = originalID(chirp)
+	if id, ok := tx.data.idx.likeByKey[likeKey{chirpID, userID}]; ok {
+		return tx.data.Likes[id], nil
+	}
+
+	like := Like{
+		ID:        tx.nextLikeID(),
+		ChirpID:   chirpID,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+	}
+	tx.putLike(like)
+	tx.emit(ChirpLiked{ChirpID: chirpID, UserID: userID})
+
+	return like, nil
+}
+
+func (tx *Tx) UnlikeChirp(chirpID, userID int) (int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Likes of a deleted chirp are hidden, but can still be taken back.
+	chirp, ok := tx.data.Chirps[chirpID]
+	if !ok {
+		return 0, ErrNotExist
+	}
+	chirpID = originalID(chirp)
+	id, ok := tx.data.idx.likeByKey[likeKey{chirpID, userID}]
+	if !ok {
+		return chirpID, nil
+	}
+	tx.removeLike(id)
+	tx.emit(ChirpUnliked{ChirpID: chirpID, UserID: userID})
+
+	return chirpID, nil
+}
+
+// GetChirpLikes returns up to limit likes of the chirp with ID chirpID
+// older than the like with ID before, or the newest if before is zero.
+func (tx *Tx) GetChirpLikes(chirpID, before, limit int) (LikePage, error) {
+	chirp, err := tx.GetChirp(chirpID)
+	if err != nil {
+		return LikePage{}, err
+	}
+	return tx.likePage(tx.data.idx.likesOf[originalID(chirp)], before, limit, nil), nil
+}
+
+// GetUserLikes returns up to limit of the likes by userID older than the
+// like with ID before, or the newest if before is zero. Likes of chirps
+// that have since been deleted are left out.
+func (tx *Tx) GetUserLikes(userID, before, limit int) (LikePage, error) {
+	if _, err := tx.GetUser(userID); err != nil {
+		return LikePage{}, err
+	}
+	return tx.likePage(tx.data.idx.likesBy[userID], before, limit, func(like Like) bool {
+		_, err := tx.GetChirp(like.ChirpID)
+		return err == nil
+	}), nil
+}
+
+// likePage pages through ids, the like IDs from an index, newest first,
+// skipping likes that keep rejects.
+func (tx *Tx) likePage(ids []int, before, limit int, keep func(Like) bool) LikePage {
+	end := len(ids)
+	if before > 0 {
+		end = sort.SearchInts(ids, before)
+	}
+	page := LikePage{Likes: []Like{}}
+	for i := end - 1; i >= 0; i-- {
+		like := tx.data.Likes[ids[i]]
+		if keep != nil && !keep(like) {
+			continue
+		}
+		if len(page.Likes) == limit {
+			page.NextBefore = page.Likes[limit-1].ID
+			break
+		}
+		page.Likes = append(page.Likes, like)
+	}
+	return page
+}
+
+// removeChirpLikes removes the likes of the chirp with ID chirpID.
+func (tx *Tx) removeChirpLikes(chirpID int) {
+	for _, id := range tx.data.idx.likesOf[chirpID] {
+		tx.removeLike(id)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// lockFile opens path without locking it; advisory locks are only
+// supported on Unix.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// migration upgrades a DBStructure by one schema version and describes
+// each change it made.
+type migration struct {
+	description string
+	apply       func(data *DBStructure) ([]string, error)
+}
+
+// migrations are applied in order; migrations[i] takes a database from
+// schema version i to i+1. Never edit a migration that has shipped; append
+// a new one instead.
+var migrations = []migration{
+	{
+		description: "add ID sequences and fill in missing collections",
+		apply: func(data *DBStructure) ([]string, error) {
+			changes := []string{}
+			if data.Chirps == nil {
+				data.Chirps = map[int]Chirp{}
+				changes = append(changes, "created chirps collection")
+			}
+			if data.Users == nil {
+				data.Users = map[int]User{}
+				changes = append(changes, "created users collection")
+			}
+			if data.RefreshTokens == nil {
+				data.RefreshTokens = map[string]RefreshToken{}
+				changes = append(changes, "created refresh_tokens collection")
+			}
+			return append(changes, repairIDs(data)...), nil
+		},
+	},
+	{
+		description: "add record versions",
+		apply: func(data *DBStructure) ([]string, error) {
+			users, chirps := 0, 0
+			for id, user := range data.Users {
+				if user.Version == 0 {
+					user.Version = 1
+					data.Users[id] = user
+					users++
+				}
+			}
+			for id, chirp := range data.Chirps {
+				if chirp.Version == 0 {
+					chirp.Version = 1
+					data.Chirps[id] = chirp
+					chirps++
+				}
+			}
+			return []string{fmt.Sprintf("set version 1 on %d users and %d chirps", users, chirps)}, nil
+		},
+	},
+	{
+		description: "add chirp timestamps",
+		apply: func(data *DBStructure) ([]string, error) {
+			// When older chirps were posted wasn't recorded, so they
+			// are treated as posted now.
+			now := time.Now().UTC()
+			n := 0
+			for id, chirp := range data.Chirps {
+				if chirp.CreatedAt.IsZero() {
+					chirp.CreatedAt = now
+					chirp.UpdatedAt = now
+					data.Chirps[id] = chirp
+					n++
+				}
+			}
+			return []string{fmt.Sprintf("set created_at and updated_at on %d chirps", n)}, nil
+		},
+	},
+	{
+		description: "add likes",
+		apply: func(data *DBStructure) ([]string, error) {
+			if data.Likes != nil {
+				return nil, nil
+			}
+			data.Likes = map[int]Like{}
+			return []string{"created likes collection"}, nil
+		},
+	},
+	{
+		description: "flag masked chirps",
+		apply: func(data *DBStructure) ([]string, error) {
+			// Older chirps are marked masked if their body contains the
+			// mask, as search used to decide.
+			n := 0
+			for id, chirp := range data.Chirps {
+				if !chirp.Masked && strings.Contains(chirp.Body, MaskedWord) {
+					chirp.Masked = true
+					data.Chirps[id] = chirp
+					n++
+				}
+			}
+			return []string{fmt.Sprintf("set masked on %d chirps", n)}, nil
+		},
+	},
+}
+
+func latestSchemaVersion() int {
+	return len(migrations)
+}
+
+// applyMigrations upgrades data in place to the latest schema version and
+// returns a report of what changed.
+func applyMigrations(data *DBStructure) ([]string, error) {
+	if data.SchemaVersion > latestSchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than this binary supports (%d)", data.SchemaVersion, latestSchemaVersion())
+	}
+
+	report := []string{}
+	for data.SchemaVersion < latestSchemaVersion() {
+		m := migrations[data.SchemaVersion]
+		version := data.SchemaVersion + 1
+		changes, err := m.apply(data)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", version, m.description, err)
+		}
+		report = append(report, fmt.Sprintf("v%d: %s", version, m.description))
+		for _, change := range changes {
+			report = append(report, "    "+change)
+		}
+		data.SchemaVersion = version
+	}
+	return report, nil
+}
+
+// migrateDB brings the database file up to the latest schema version,
+// copying the old file aside first.
+func (db *DB) migrateDB(dbStructure *DBStructure) error {
+	if dbStructure.SchemaVersion == latestSchemaVersion() {
+		return nil
+	}
+	from := dbStructure.SchemaVersion
+
+	report, err := applyMigrations(dbStructure)
+	if err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.schema-v%d-%s", db.path, from, time.Now().UTC().Format("20060102T150405"))
+	dat, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+	dat, _, err = db.keys.open(dat)
+	if err != nil {
+		return err
+	}
+	dat, err = db.keys.seal(dat)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(backup, "", dat)
+	if err != nil {
+		return fmt.Errorf("couldn't back up database before migrating: %w", err)
+	}
+	log.Printf("Backed up %s to %s", db.path, backup)
+
+	err = db.writeDB(*dbStructure)
+	if err != nil {
+		return err
+	}
+	for _, line := range report {
+		log.Printf("Migrated %s %s", db.path, line)
+	}
+	return nil
+}
+
+// PlanMigrations reports the migrations that opening the database at path
+// would apply, without changing anything on disk. keys is only needed if
+// the file is encrypted.
+func PlanMigrations(path string, keys *Keyring) ([]string, error) {
+	dbStructure, _, err := readDBFile(path, keys)
+	if err != nil {
+		return nil, err
+	}
+	return applyMigrations(&dbStructure)
+}
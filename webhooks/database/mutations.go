@@ -0,0 +1,218 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// mutation is one change to a collection, as recorded in the write-ahead
+// log. Every change a Tx makes to the stored collections goes through the
+// helpers below, which apply it, remember how to undo it and record it.
+type mutation struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+const (
+	opPutChirp           = "put_chirp"
+	opDeleteChirp        = "delete_chirp"
+	opPutUser            = "put_user"
+	opPutRefreshToken    = "put_refresh_token"
+	opDeleteRefreshToken = "delete_refresh_token"
+	opPutLike            = "put_like"
+	opDeleteLike         = "delete_like"
+	opSetSequence        = "set_sequence"
+	opAppendEvent        = "append_event"
+)
+
+func (tx *Tx) record(op, key string, value any) {
+	m := mutation{Op: op, Key: key}
+	if value != nil {
+		dat, err := json.Marshal(value)
+		if err != nil {
+			// Only plain data types are stored, so this can't happen.
+			panic(err)
+		}
+		m.Value = dat
+	}
+	tx.mutations = append(tx.mutations, m)
+}
+
+// putChirp and removeChirp change the chirps collection and its indexes.
+func (tx *Tx) putChirp(chirp Chirp) {
+	old, existed := tx.data.Chirps[chirp.ID]
+	if !existed {
+		addToIndex(tx, tx.data.idx.chirpsByAuthor, chirp.AuthorID, chirp.ID)
+	}
+	moveInIndex(tx, tx.data.idx.repliesTo, old.InReplyTo, chirp.InReplyTo, chirp.ID)
+	moveInIndex(tx, tx.data.idx.rechirpsOf, old.RechirpOf, chirp.RechirpOf, chirp.ID)
+	moveInIndex(tx, tx.data.idx.quotesOf, old.QuoteOf, chirp.QuoteOf, chirp.ID)
+	put(tx, tx.data.Chirps, chirp.ID, chirp)
+	tx.reindexChirp(chirp.ID, old, existed)
+	tx.record(opPutChirp, strconv.Itoa(chirp.ID), chirp)
+}
+
+func (tx *Tx) removeChirp(id int) {
+	chirp, ok := tx.data.Chirps[id]
+	if !ok {
+		return
+	}
+	removeFromIndex(tx, tx.data.idx.chirpsByAuthor, chirp.AuthorID, id)
+	moveInIndex(tx, tx.data.idx.repliesTo, chirp.InReplyTo, 0, id)
+	moveInIndex(tx, tx.data.idx.rechirpsOf, chirp.RechirpOf, 0, id)
+	moveInIndex(tx, tx.data.idx.quotesOf, chirp.QuoteOf, 0, id)
+	remove(tx, tx.data.Chirps, id)
+	tx.reindexChirp(id, chirp, true)
+	tx.record(opDeleteChirp, strconv.Itoa(id), nil)
+}
+
+// putUser changes the users collection and the email index. It fails with
+// ErrAlreadyExists if another user has the same email.
+func (tx *Tx) putUser(user User) error {
+	if ownerID, ok := tx.data.idx.userByEmail[user.Email]; ok && ownerID != user.ID {
+		return ErrAlreadyExists
+	}
+	if old, ok := tx.data.Users[user.ID]; ok && old.Email != user.Email {
+		remove(tx, tx.data.idx.userByEmail, old.Email)
+	}
+	put(tx, tx.data.idx.userByEmail, user.Email, user.ID)
+	put(tx, tx.data.Users, user.ID, user)
+	tx.record(opPutUser, strconv.Itoa(user.ID), user)
+	return nil
+}
+
+func (tx *Tx) putRefreshToken(token RefreshToken) {
+	put(tx, tx.data.RefreshTokens, token.Token, token)
+	tx.record(opPutRefreshToken, token.Token, token)
+}
+
+func (tx *Tx) removeRefreshToken(token string) {
+	if _, ok := tx.data.RefreshTokens[token]; !ok {
+		return
+	}
+	remove(tx, tx.data.RefreshTokens, token)
+	tx.record(opDeleteRefreshToken, token, nil)
+}
+
+// putLike and removeLike change the likes collection and its indexes. A
+// like must not be put under a different chirp or user than it had.
+func (tx *Tx) putLike(like Like) {
+	if _, ok := tx.data.Likes[like.ID]; !ok {
+		addToIndex(tx, tx.data.idx.likesOf, like.ChirpID, like.ID)
+		addToIndex(tx, tx.data.idx.likesBy, like.UserID, like.ID)
+		put(tx, tx.data.idx.likeByKey, likeKey{like.ChirpID, like.UserID}, like.ID)
+	}
+	put(tx, tx.data.Likes, like.ID, like)
+	tx.record(opPutLike, strconv.Itoa(like.ID), like)
+}
+
+func (tx *Tx) removeLike(id int) {
+	like, ok := tx.data.Likes[id]
+	if !ok {
+		return
+	}
+	removeFromIndex(tx, tx.data.idx.likesOf, like.ChirpID, id)
+	removeFromIndex(tx, tx.data.idx.likesBy, like.UserID, id)
+	if tx.data.idx.likeByKey[likeKey{like.ChirpID, like.UserID}] == id {
+		remove(tx, tx.data.idx.likeByKey, likeKey{like.ChirpID, like.UserID})
+	}
+	remove(tx, tx.data.Likes, id)
+	tx.record(opDeleteLike, strconv.Itoa(id), nil)
+}
+
+func (tx *Tx) setSequence(name string, value int) {
+	var seq *int
+	switch name {
+	case "chirps":
+		seq = &tx.data.Sequences.Chirps
+	case "users":
+		seq = &tx.data.Sequences.Users
+	case "likes":
+		seq = &tx.data.Sequences.Likes
+	default:
+		panic("unknown sequence " + name)
+	}
+	old := *seq
+	tx.undo = append(tx.undo, func() {
+		*seq = old
+	})
+	*seq = value
+	tx.record(opSetSequence, name, value)
+}
+
+// appendEvent adds e to the retained events, dropping the oldest once there
+// are more than maxRetainedEvents.
+func (tx *Tx) appendEvent(e Event) {
+	oldEvents, oldSeq := tx.data.Events, tx.data.EventSeq
+	tx.undo = append(tx.undo, func() {
+		tx.data.Events, tx.data.EventSeq = oldEvents, oldSeq
+	})
+	events := append(tx.data.Events, e)
+	if len(events) > maxRetainedEvents {
+		events = events[len(events)-maxRetainedEvents:]
+	}
+	tx.data.Events, tx.data.EventSeq = events, e.Seq
+	tx.record(opAppendEvent, strconv.FormatUint(e.Seq, 10), e)
+}
+
+// applyMutation replays a recorded mutation.
+func (tx *Tx) applyMutation(m mutation) error {
+	switch m.Op {
+	case opPutChirp:
+		chirp := Chirp{}
+		if err := json.Unmarshal(m.Value, &chirp); err != nil {
+			return err
+		}
+		tx.putChirp(chirp)
+	case opDeleteChirp:
+		id, err := strconv.Atoi(m.Key)
+		if err != nil {
+			return err
+		}
+		tx.removeChirp(id)
+	case opPutUser:
+		user := User{}
+		if err := json.Unmarshal(m.Value, &user); err != nil {
+			return err
+		}
+		return tx.putUser(user)
+	case opPutRefreshToken:
+		token := RefreshToken{}
+		if err := json.Unmarshal(m.Value, &token); err != nil {
+			return err
+		}
+		tx.putRefreshToken(token)
+	case opDeleteRefreshToken:
+		tx.removeRefreshToken(m.Key)
+	case opPutLike:
+		like := Like{}
+		if err := json.Unmarshal(m.Value, &like); err != nil {
+			return err
+		}
+		tx.putLike(like)
+	case opDeleteLike:
+		id, err := strconv.Atoi(m.Key)
+		if err != nil {
+			return err
+		}
+		tx.removeLike(id)
+	case opSetSequence:
+		var value int
+		if err := json.Unmarshal(m.Value, &value); err != nil {
+			return err
+		}
+		tx.setSequence(m.Key, value)
+	case opAppendEvent:
+		e := Event{}
+		if err := json.Unmarshal(m.Value, &e); err != nil {
+			return err
+		}
+		tx.appendEvent(e)
+		tx.events = append(tx.events, e)
+	default:
+		return fmt.Errorf("unknown mutation %q", m.Op)
+	}
+	return nil
+}
@@ -0,0 +1,494 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	CollectionUsers         = "users"
+	CollectionChirps        = "chirps"
+	CollectionRefreshTokens = "refresh_tokens"
+	CollectionLikes         = "likes"
+)
+
+// ndjsonRecord is one line of an export.
+type ndjsonRecord struct {
+	Collection string          `json:"collection"`
+	Record     json.RawMessage `json:"record"`
+}
+
+type ExportOptions struct {
+	// Collections limits the export to the named collections. Empty means
+	// all of them.
+	Collections           []string
+	ExcludePasswordHashes bool
+	ExcludeRefreshTokens  bool
+}
+
+func (opts ExportOptions) includes(collection string) bool {
+	if collection == CollectionRefreshTokens && opts.ExcludeRefreshTokens {
+		return false
+	}
+	if len(opts.Collections) == 0 {
+		return true
+	}
+	for _, c := range opts.Collections {
+		if c == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportNDJSON writes the contents of store to w as newline-delimited JSON,
+// one record per line: users first, then chirps, likes and refresh tokens,
+// each in order of ID (or token). It works from a Snapshot, so the whole
+// store is held in memory while it runs.
+func ExportNDJSON(w io.Writer, store Store, opts ExportOptions) error {
+	data, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	write := func(collection string, record any) error {
+		dat, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(ndjsonRecord{Collection: collection, Record: dat})
+	}
+
+	if opts.includes(CollectionUsers) {
+		for _, id := range sortedKeys(data.Users) {
+			user := data.Users[id]
+			if opts.ExcludePasswordHashes {
+				user.HashedPassword = ""
+			}
+			if err := write(CollectionUsers, user); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.includes(CollectionChirps) {
+		for _, id := range sortedKeys(data.Chirps) {
+			if err := write(CollectionChirps, data.Chirps[id]); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.includes(CollectionLikes) {
+		for _, id := range sortedKeys(data.Likes) {
+			if err := write(CollectionLikes, data.Likes[id]); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.includes(CollectionRefreshTokens) {
+		for _, token := range sortedKeys(data.RefreshTokens) {
+			if err := write(CollectionRefreshTokens, data.RefreshTokens[token]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type ImportOptions struct {
+	// RemapIDs gives imported users, chirps and likes fresh IDs and
+	// rewrites the references to them. Without it, IDs are kept and must
+	// be unused.
+	RemapIDs bool
+}
+
+type ImportResult struct {
+	Users         int `json:"users"`
+	Chirps        int `json:"chirps"`
+	RefreshTokens int `json:"refresh_tokens"`
+	Likes         int `json:"likes"`
+}
+
+// ImportError is a problem with one line of an import.
+type ImportError struct {
+	Line int    `json:"line"`
+	Err  string `json:"error"`
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// ImportErrors is returned when any line of an import fails validation.
+// Nothing is imported in that case.
+type ImportErrors []ImportError
+
+func (errs ImportErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ImportNDJSON reads records written by ExportNDJSON from r, validates all
+// of them and adds them to store in one go. Every record is held in memory
+// until then, so the input has to fit.
+func ImportNDJSON(r io.Reader, store Store, opts ImportOptions) (ImportResult, error) {
+	data := DBStructure{
+		Chirps:        map[int]Chirp{},
+		Users:         map[int]User{},
+		RefreshTokens: map[string]RefreshToken{},
+		Likes:         map[int]Like{},
+	}
+	lines := map[string]int{}
+	errs := ImportErrors{}
+	fail := func(line int, format string, args ...any) {
+		errs = append(errs, ImportError{Line: line, Err: fmt.Sprintf(format, args...)})
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		record := ndjsonRecord{}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			fail(line, "invalid JSON: %s", err)
+			continue
+		}
+
+		switch record.Collection {
+		case CollectionUsers:
+			user := User{}
+			if err := json.Unmarshal(record.Record, &user); err != nil {
+				fail(line, "invalid user: %s", err)
+				continue
+			}
+			if user.ID <= 0 {
+				fail(line, "user has no id")
+				continue
+			}
+			if user.Email == "" {
+				fail(line, "user %d has no email", user.ID)
+				continue
+			}
+			if _, ok := data.Users[user.ID]; ok {
+				fail(line, "user %d appears more than once", user.ID)
+				continue
+			}
+			data.Users[user.ID] = user
+			lines[fmt.Sprintf("user:%d", user.ID)] = line
+		case CollectionChirps:
+			chirp := Chirp{}
+			if err := json.Unmarshal(record.Record, &chirp); err != nil {
+				fail(line, "invalid chirp: %s", err)
+				continue
+			}
+			if chirp.ID <= 0 {
+				fail(line, "chirp has no id")
+				continue
+			}
+			if chirp.RechirpOf == 0 && chirp.Body == "" {
+				fail(line, "chirp %d has no body", chirp.ID)
+				continue
+			}
+			if chirp.RechirpOf != 0 && chirp.Body != "" {
+				fail(line, "chirp %d is a rechirp but has a body", chirp.ID)
+				continue
+			}
+			if _, ok := data.Chirps[chirp.ID]; ok {
+				fail(line, "chirp %d appears more than once", chirp.ID)
+				continue
+			}
+			data.Chirps[chirp.ID] = chirp
+			lines[fmt.Sprintf("chirp:%d", chirp.ID)] = line
+		case CollectionLikes:
+			like := Like{}
+			if err := json.Unmarshal(record.Record, &like); err != nil {
+				fail(line, "invalid like: %s", err)
+				continue
+			}
+			if like.ID <= 0 {
+				fail(line, "like has no id")
+				continue
+			}
+			if _, ok := data.Likes[like.ID]; ok {
+				fail(line, "like %d appears more than once", like.ID)
+				continue
+			}
+			data.Likes[like.ID] = like
+			lines[fmt.Sprintf("like:%d", like.ID)] = line
+		case CollectionRefreshTokens:
+			token := RefreshToken{}
+			if err := json.Unmarshal(record.Record, &token); err != nil {
+				fail(line, "invalid refresh token: %s", err)
+				continue
+			}
+			if token.Token == "" {
+				fail(line, "refresh token has no token")
+				continue
+			}
+			if token.ExpiresAt.IsZero() {
+				fail(line, "refresh token has no expires_at")
+				continue
+			}
+			if _, ok := data.RefreshTokens[token.Token]; ok {
+				fail(line, "refresh token appears more than once")
+				continue
+			}
+			data.RefreshTokens[token.Token] = token
+			lines["token:"+token.Token] = line
+		default:
+			fail(line, "unknown collection %q", record.Collection)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ImportResult{}, err
+	}
+
+	// Check the records against each other and against what's already
+	// stored, so that every problem is reported with its line.
+	emails := map[string]int{}
+	for _, id := range sortedKeys(data.Users) {
+		user := data.Users[id]
+		line := lines[fmt.Sprintf("user:%d", id)]
+		if other, ok := emails[user.Email]; ok {
+			fail(line, "email %q is also used by user %d", user.Email, other)
+		}
+		emails[user.Email] = id
+		if _, err := store.GetUserByEmail(user.Email); err == nil {
+			fail(line, "email %q is already in use", user.Email)
+		}
+		if !opts.RemapIDs {
+			if _, err := store.GetUser(id); err == nil {
+				fail(line, "user %d already exists", id)
+			}
+		}
+	}
+	userExists := func(id int) bool {
+		if _, ok := data.Users[id]; ok {
+			return true
+		}
+		_, err := store.GetUser(id)
+		return err == nil
+	}
+	chirpExists := func(id int) bool {
+		if _, ok := data.Chirps[id]; ok {
+			return true
+		}
+		if _, err := store.GetChirp(id); err == nil {
+			return true
+		}
+		_, err := store.GetDeletedChirp(id)
+		return err == nil
+	}
+	for _, id := range sortedKeys(data.Chirps) {
+		chirp := data.Chirps[id]
+		line := lines[fmt.Sprintf("chirp:%d", id)]
+		if !userExists(chirp.AuthorID) {
+			fail(line, "chirp %d: author %d doesn't exist", id, chirp.AuthorID)
+		}
+		if chirp.InReplyTo != 0 && !chirpExists(chirp.InReplyTo) {
+			fail(line, "chirp %d: chirp %d it replies to doesn't exist", id, chirp.InReplyTo)
+		}
+		if chirp.RechirpOf != 0 && !chirpExists(chirp.RechirpOf) {
+			fail(line, "chirp %d: chirp %d it rechirps doesn't exist", id, chirp.RechirpOf)
+		}
+		if chirp.QuoteOf != 0 && !chirpExists(chirp.QuoteOf) {
+			fail(line, "chirp %d: chirp %d it quotes doesn't exist", id, chirp.QuoteOf)
+		}
+		if !opts.RemapIDs {
+			_, err := store.GetChirp(id)
+			if err != nil {
+				_, err = store.GetDeletedChirp(id)
+			}
+			if err == nil {
+				fail(line, "chirp %d already exists", id)
+			}
+		}
+	}
+	liked := map[likeKey]int{}
+	for _, id := range sortedKeys(data.Likes) {
+		like := data.Likes[id]
+		line := lines[fmt.Sprintf("like:%d", id)]
+		if !userExists(like.UserID) {
+			fail(line, "like %d: user %d doesn't exist", id, like.UserID)
+		}
+		if !chirpExists(like.ChirpID) {
+			fail(line, "like %d: chirp %d doesn't exist", id, like.ChirpID)
+		}
+		key := likeKey{like.ChirpID, like.UserID}
+		if other, ok := liked[key]; ok {
+			fail(line, "like %d: user %d also likes chirp %d in like %d", id, like.UserID, like.ChirpID, other)
+		}
+		liked[key] = id
+	}
+	for _, token := range data.RefreshTokens {
+		line := lines["token:"+token.Token]
+		if !userExists(token.UserID) {
+			fail(line, "refresh token: user %d doesn't exist", token.UserID)
+		}
+	}
+
+	if len(errs) > 0 {
+		sortImportErrors(errs)
+		return ImportResult{}, errs
+	}
+
+	err := store.Import(data, opts.RemapIDs)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return ImportResult{
+		Users:         len(data.Users),
+		Chirps:        len(data.Chirps),
+		RefreshTokens: len(data.RefreshTokens),
+		Likes:         len(data.Likes),
+	}, nil
+}
+
+func sortImportErrors(errs ImportErrors) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+}
+
+// ErrMissingReference is returned by Import when a record refers to a user
+// or chirp that is neither imported nor stored, and by CreateChirp when the
+// chirp being replied to doesn't exist.
+var ErrMissingReference = errors.New("record refers to a user or chirp that doesn't exist")
+
+func (db *DB) Import(data DBStructure, remapIDs bool) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.Import(data, remapIDs)
+	})
+}
+
+// Import adds the users, chirps, likes and refresh tokens in data. With
+// remapIDs, users, chirps and likes get fresh IDs and references to
+// imported records are rewritten; references to records that aren't in
+// data are left alone.
+func (tx *Tx) Import(data DBStructure, remapIDs bool) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	userIDs := map[int]int{}
+	for _, id := range sortedKeys(data.Users) {
+		user := data.Users[id]
+		if remapIDs {
+			user.ID = tx.nextUserID()
+		} else {
+			if _, err := tx.GetUser(id); err == nil {
+				return fmt.Errorf("user %d: %w", id, ErrAlreadyExists)
+			}
+			if id > tx.data.Sequences.Users {
+				tx.setSequence("users", id)
+			}
+		}
+		if err := tx.putUser(user); err != nil {
+			return fmt.Errorf("user %d: %w", id, err)
+		}
+		userIDs[id] = user.ID
+	}
+	resolveUser := func(id int) (int, error) {
+		if newID, ok := userIDs[id]; ok {
+			return newID, nil
+		}
+		if _, err := tx.GetUser(id); err != nil {
+			return 0, fmt.Errorf("user %d: %w", id, ErrMissingReference)
+		}
+		return id, nil
+	}
+
+	// Replies come after the chirps they reply to, so in ID order those
+	// have already been given their new IDs.
+	chirpIDs := map[int]int{}
+	resolveChirp := func(id int) (int, error) {
+		if newID, ok := chirpIDs[id]; ok {
+			return newID, nil
+		}
+		if _, ok := tx.data.Chirps[id]; !ok {
+			return 0, fmt.Errorf("chirp %d: %w", id, ErrMissingReference)
+		}
+		return id, nil
+	}
+
+	for _, id := range sortedKeys(data.Chirps) {
+		chirp := data.Chirps[id]
+		authorID, err := resolveUser(chirp.AuthorID)
+		if err != nil {
+			return fmt.Errorf("chirp %d: %w", id, err)
+		}
+		chirp.AuthorID = authorID
+		for _, ref := range []*int{&chirp.InReplyTo, &chirp.RechirpOf, &chirp.QuoteOf} {
+			if *ref == 0 {
+				continue
+			}
+			*ref, err = resolveChirp(*ref)
+			if err != nil {
+				return fmt.Errorf("chirp %d: %w", id, err)
+			}
+		}
+		if remapIDs {
+			chirp.ID = tx.nextChirpID()
+		} else {
+			if _, ok := tx.data.Chirps[id]; ok {
+				return fmt.Errorf("chirp %d: %w", id, ErrAlreadyExists)
+			}
+			if id > tx.data.Sequences.Chirps {
+				tx.setSequence("chirps", id)
+			}
+		}
+		tx.putChirp(chirp)
+		chirpIDs[id] = chirp.ID
+	}
+
+	for _, id := range sortedKeys(data.Likes) {
+		like := data.Likes[id]
+		var err error
+		like.UserID, err = resolveUser(like.UserID)
+		if err != nil {
+			return fmt.Errorf("like %d: %w", id, err)
+		}
+		like.ChirpID, err = resolveChirp(like.ChirpID)
+		if err != nil {
+			return fmt.Errorf("like %d: %w", id, err)
+		}
+		if _, ok := tx.data.idx.likeByKey[likeKey{like.ChirpID, like.UserID}]; ok {
+			return fmt.Errorf("like %d: %w", id, ErrAlreadyExists)
+		}
+		if remapIDs {
+			like.ID = tx.nextLikeID()
+		} else {
+			if _, ok := tx.data.Likes[id]; ok {
+				return fmt.Errorf("like %d: %w", id, ErrAlreadyExists)
+			}
+			if id > tx.data.Sequences.Likes {
+				tx.setSequence("likes", id)
+			}
+		}
+		tx.putLike(like)
+	}
+
+	for _, token := range data.RefreshTokens {
+		userID, err := resolveUser(token.UserID)
+		if err != nil {
+			return fmt.Errorf("refresh token: %w", err)
+		}
+		token.UserID = userID
+		tx.putRefreshToken(token)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package database
+
+import "time"
+
+// ChirpStats counts the live rechirps and quotes of a chirp, and its likes.
+type ChirpStats struct {
+	Rechirps int
+	Quotes   int
+	Likes    int
+}
+
+// CreateRechirp shares the chirp with ID chirpID on behalf of userID. A
+// rechirp of a rechirp shares the original instead. It fails with
+// ErrNotExist if the chirp doesn't exist and ErrAlreadyExists if the user
+// has already rechirped it.
+func (db *DB) CreateRechirp(chirpID, userID int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.CreateRechirp(chirpID, userID)
+		return err
+	})
+	return chirp, err
+}
+
+// DeleteRechirp undoes userID's rechirp of the chirp with ID chirpID,
+// leaving a tombstone like DeleteChirp. It fails with ErrNotExist if there
+// is no such rechirp.
+func (db *DB) DeleteRechirp(chirpID, userID int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.DeleteRechirp(chirpID, userID)
+		return err
+	})
+	return chirp, err
+}
+
+// CreateQuote posts a chirp quoting the chirp with ID quoteOf. A quote of a
+// rechirp quotes the original instead. It fails with ErrNotExist if the
+// chirp doesn't exist.
+func (db *DB) CreateQuote(body string, masked bool, authorID, quoteOf int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.CreateQuote(body, masked, authorID, quoteOf)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) GetChirpStats(ids []int) (map[int]ChirpStats, error) {
+	var stats map[int]ChirpStats
+	err := db.View(func(tx *Tx) error {
+		stats = tx.GetChirpStats(ids)
+		return nil
+	})
+	return stats, err
+}
+
+func (db *DB) GetChirpsByID(ids []int) (map[int]Chirp, error) {
+	var chirps map[int]Chirp
+	err := db.View(func(tx *Tx) error {
+		chirps = tx.GetChirpsByID(ids)
+		return nil
+	})
+	return chirps, err
+}
+
+// originalID returns the ID of the chirp that chirp stands for: what it
+// shares if it is a rechirp, and otherwise itself.
+func originalID(chirp Chirp) int {
+	if chirp.RechirpOf != 0 {
+		return chirp.RechirpOf
+	}
+	return chirp.ID
+}
+
+// findRechirp returns userID's live rechirp of the chirp with ID chirpID.
+func (tx *Tx) findRechirp(chirpID, userID int) (Chirp, bool) {
+	for _, id := range tx.data.idx.rechirpsOf[chirpID] {
+		chirp := tx.data.Chirps[id]
+		if chirp.AuthorID == userID && !chirp.Deleted() {
+			return chirp, true
+		}
+	}
+	return Chirp{}, false
+}
+
+func (tx *Tx) CreateRechirp(chirpID, userID int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+
+	original, err := tx.GetChirp(chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirpID = originalID(original)
+	if _, ok := tx.findRechirp(chirpID, userID); ok {
+		return Chirp{}, ErrAlreadyExists
+	}
+
+	now := time.Now().UTC()
+	chirp := Chirp{
+		ID:        tx.nextChirpID(),
+		AuthorID:  userID,
+		RechirpOf: chirpID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	tx.putChirp(chirp)
+	tx.emit(ChirpCreated{Chirp: chirp})
+
+	return chirp, nil
+}
+
+func (tx *Tx) DeleteRechirp(chirpID, userID int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+
+	// Rechirps of a deleted chirp are hidden, but can still be undone.
+	if original, ok := tx.data.Chirps[chirpID]; ok {
+		chirpID = originalID(original)
+	}
+	chirp, ok := tx.findRechirp(chirpID, userID)
+	if !ok {
+		return Chirp{}, ErrNotExist
+	}
+	now := time.Now().UTC()
+	chirp.DeletedAt = &now
+	chirp.DeletedBy = userID
+	chirp.Version++
+	tx.putChirp(chirp)
+	tx.emit(ChirpDeleted{ChirpID: chirp.ID, AuthorID: userID, DeletedBy: userID})
+
+	return chirp, nil
+}
+
+func (tx *Tx) CreateQuote(body string, masked bool, authorID, quoteOf int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+
+	original, err := tx.GetChirp(quoteOf)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	now := time.Now().UTC()
+	chirp := Chirp{
+		ID:        tx.nextChirpID(),
+		AuthorID:  authorID,
+		Body:      body,
+		Masked:    masked,
+		QuoteOf:   originalID(original),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	tx.putChirp(chirp)
+	tx.emit(ChirpCreated{Chirp: chirp})
+
+	return chirp, nil
+}
+
+// GetChirpStats returns the stats of each chirp in ids.
+func (tx *Tx) GetChirpStats(ids []int) map[int]ChirpStats {
+	stats := make(map[int]ChirpStats, len(ids))
+	for _, id := range ids {
+		s := ChirpStats{}
+		for _, rechirpID := range tx.data.idx.rechirpsOf[id] {
+			if !tx.data.Chirps[rechirpID].Deleted() {
+				s.Rechirps++
+			}
+		}
+		for _, quoteID := range tx.data.idx.quotesOf[id] {
+			if !tx.data.Chirps[quoteID].Deleted() {
+				s.Quotes++
+			}
+		}
+		s.Likes = len(tx.data.idx.likesOf[id])
+		stats[id] = s
+	}
+	return stats
+}
+
+// GetChirpsByID returns the chirps in ids that exist, including deleted
+// ones, for showing what rechirps and quotes refer to.
+func (tx *Tx) GetChirpsByID(ids []int) map[int]Chirp {
+	chirps := make(map[int]Chirp, len(ids))
+	for _, id := range ids {
+		if chirp, ok := tx.data.Chirps[id]; ok {
+			chirps[id] = chirp
+		}
+	}
+	return chirps
+}
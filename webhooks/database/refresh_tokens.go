@@ -11,8 +11,39 @@ type RefreshToken struct {
 }
 
 func (db *DB) SaveRefreshToken(userID int, token string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
+	return db.Update(func(tx *Tx) error {
+		return tx.SaveRefreshToken(userID, token)
+	})
+}
+
+func (db *DB) RevokeRefreshToken(token string) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.RevokeRefreshToken(token)
+	})
+}
+
+func (db *DB) PurgeExpiredRefreshTokens(expiredBefore time.Time) (int, error) {
+	var purged int
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		purged, err = tx.PurgeExpiredRefreshTokens(expiredBefore)
+		return err
+	})
+	return purged, err
+}
+
+func (db *DB) UserForRefreshToken(token string) (User, error) {
+	var user User
+	err := db.View(func(tx *Tx) error {
+		var err error
+		user, err = tx.UserForRefreshToken(token)
+		return err
+	})
+	return user, err
+}
+
+func (tx *Tx) SaveRefreshToken(userID int, token string) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
 
@@ -21,35 +52,43 @@ func (db *DB) SaveRefreshToken(userID int, token string) error {
 		Token:     token,
 		ExpiresAt: time.Now().Add(time.Hour),
 	}
-	dbStructure.RefreshTokens[token] = refreshToken
+	tx.putRefreshToken(refreshToken)
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
 	return nil
 }
 
-func (db *DB) RevokeRefreshToken(token string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
+func (tx *Tx) RevokeRefreshToken(token string) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
-	delete(dbStructure.RefreshTokens, token)
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
+	refreshToken, ok := tx.data.RefreshTokens[token]
+	if !ok {
+		return nil
 	}
+	tx.removeRefreshToken(token)
+	tx.emit(TokenRevoked{UserID: refreshToken.UserID})
 	return nil
 }
 
-func (db *DB) UserForRefreshToken(token string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
+// PurgeExpiredRefreshTokens removes the tokens that expired before
+// expiredBefore and returns how many there were.
+func (tx *Tx) PurgeExpiredRefreshTokens(expiredBefore time.Time) (int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
 	}
 
-	refreshToken, ok := dbStructure.RefreshTokens[token]
+	purged := 0
+	for token, refreshToken := range tx.data.RefreshTokens {
+		if refreshToken.ExpiresAt.Before(expiredBefore) {
+			tx.removeRefreshToken(token)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (tx *Tx) UserForRefreshToken(token string) (User, error) {
+	refreshToken, ok := tx.data.RefreshTokens[token]
 	if !ok {
 		return User{}, ErrNotExist
 	}
@@ -58,10 +97,5 @@ func (db *DB) UserForRefreshToken(token string) (User, error) {
 		return User{}, ErrNotExist
 	}
 
-	user, err := db.GetUser(refreshToken.UserID)
-	if err != nil {
-		return User{}, err
-	}
-
-	return user, nil
-}
\ No newline at end of file
+	return tx.GetUser(refreshToken.UserID)
+}
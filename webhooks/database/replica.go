@@ -0,0 +1,111 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// ErrLocked means another process has the database open for writing.
+var ErrLocked = errors.New("database is locked by another process")
+
+// defaultReloadInterval is how often a read-only DB checks whether the
+// file has changed.
+const defaultReloadInterval = time.Second
+
+func (db *DB) lockPath() string {
+	return db.path + ".lock"
+}
+
+// fileState identifies a version of the database file and write-ahead log
+// well enough to notice that either has been written to.
+type fileState struct {
+	modTime    time.Time
+	size       int64
+	walModTime time.Time
+	walSize    int64
+}
+
+func (db *DB) statFiles() (fileState, error) {
+	state := fileState{}
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return state, err
+	}
+	state.modTime, state.size = info.ModTime(), info.Size()
+
+	info, err = os.Stat(db.walPath())
+	if err == nil {
+		state.walModTime, state.walSize = info.ModTime(), info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return state, err
+	}
+	return state, nil
+}
+
+// loadReadOnly reads the database file and the write-ahead log without
+// changing either. Unlike ensureDB it won't create, recover or migrate the
+// file; that is left to the process that has it open for writing.
+func (db *DB) loadReadOnly() (DBStructure, error) {
+	dbStructure, _, err := readDBFile(db.path, db.keys)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	if dbStructure.SchemaVersion != latestSchemaVersion() {
+		return DBStructure{}, fmt.Errorf("%s is at schema version %d, want %d; open it for writing once to migrate it",
+			db.path, dbStructure.SchemaVersion, latestSchemaVersion())
+	}
+	dbStructure.buildIndexes()
+	_, err = db.replayLog(&dbStructure)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	return dbStructure, nil
+}
+
+// reloadLoop polls the database file and reloads it when another process
+// has written to it.
+func (db *DB) reloadLoop(state fileState) {
+	defer close(db.stopped)
+
+	ticker := time.NewTicker(db.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			current, err := db.statFiles()
+			if err != nil {
+				log.Printf("Couldn't check %s for changes: %s", db.path, err)
+				continue
+			}
+			if current == state {
+				continue
+			}
+			err = db.reload()
+			if errors.Is(err, errLogGap) {
+				// Caught the writer mid-compaction; try again next tick.
+				continue
+			}
+			if err != nil {
+				log.Printf("Couldn't reload %s: %s", db.path, err)
+				continue
+			}
+			state = current
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+func (db *DB) reload() error {
+	dbStructure, err := db.loadReadOnly()
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	db.data = &dbStructure
+	db.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"maps"
+)
+
+// maxRetainedLogEntries is how many recent transactions a DB keeps for
+// followers to catch up from. A follower that falls further behind starts
+// again from a snapshot.
+const maxRetainedLogEntries = 10000
+
+// maxLogBatch is the most entries LogSince returns at once.
+const maxLogBatch = 1000
+
+// ErrLogTruncated means the entries a follower asked for are no longer
+// retained, or were never written by this generation of the log, so it has
+// to start again from a snapshot.
+var ErrLogTruncated = errors.New("log entries after that position are not available")
+
+// LogBatch is a run of transactions for a follower to apply. Epoch
+// identifies the log the entries belong to: it changes when the leader
+// restarts or its data is replaced, and a follower that sees it change
+// must start again from a snapshot.
+type LogBatch struct {
+	Epoch   string     `json:"epoch"`
+	LogSeq  uint64     `json:"log_seq"`
+	Entries []LogEntry `json:"entries"`
+}
+
+// ReplicaSnapshot is the full contents of a DB, for a follower to start
+// from before applying the entries after Data.LogSeq.
+type ReplicaSnapshot struct {
+	Epoch string      `json:"epoch"`
+	Data  DBStructure `json:"data"`
+}
+
+// NewReplicaDB returns an in-memory database that is kept up to date from
+// a leader with LoadSnapshot and ApplyLog. Other writes fail with
+// ErrReadOnly.
+func NewReplicaDB() *DB {
+	db := newDB("", Options{ReadOnly: true})
+	db.ensureDB()
+	close(db.stopped)
+	return db
+}
+
+func newEpoch() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// recordLog keeps entry for followers and wakes up any waiting for it.
+// Callers must hold db.mu for writing.
+func (db *DB) recordLog(entry LogEntry) {
+	db.recent = append(db.recent, entry)
+	if len(db.recent) > maxRetainedLogEntries {
+		db.recent = db.recent[len(db.recent)-maxRetainedLogEntries:]
+	}
+	close(db.committed)
+	db.committed = make(chan struct{})
+}
+
+// resetLog starts a new epoch, for when the data has been replaced
+// wholesale and the retained entries no longer lead up to it. Callers
+// must hold db.mu for writing.
+func (db *DB) resetLog() {
+	db.epoch = newEpoch()
+	db.recent = nil
+	close(db.committed)
+	db.committed = make(chan struct{})
+}
+
+// ReplicaSnapshot returns a copy of the data for a follower. Unlike
+// Snapshot, it includes the log position and the retained change events.
+func (db *DB) ReplicaSnapshot() ReplicaSnapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return ReplicaSnapshot{
+		Epoch: db.epoch,
+		Data: DBStructure{
+			SchemaVersion: db.data.SchemaVersion,
+			Chirps:        maps.Clone(db.data.Chirps),
+			Users:         maps.Clone(db.data.Users),
+			RefreshTokens: maps.Clone(db.data.RefreshTokens),
+			Likes:         maps.Clone(db.data.Likes),
+			Sequences:     db.data.Sequences,
+			LogSeq:        db.data.LogSeq,
+			Events:        append([]Event(nil), db.data.Events...),
+			EventSeq:      db.data.EventSeq,
+		},
+	}
+}
+
+// LogSeq returns the number of the last committed transaction.
+func (db *DB) LogSeq() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.data.LogSeq
+}
+
+// LogSince returns the transactions committed after the one numbered
+// after, up to maxLogBatch of them. It fails with ErrLogTruncated if they
+// are no longer retained.
+func (db *DB) LogSince(after uint64) (LogBatch, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	batch := LogBatch{Epoch: db.epoch, LogSeq: db.data.LogSeq, Entries: []LogEntry{}}
+	if after > db.data.LogSeq {
+		return batch, ErrLogTruncated
+	}
+	if after == db.data.LogSeq {
+		return batch, nil
+	}
+	if len(db.recent) == 0 || db.recent[0].Seq > after+1 {
+		return batch, ErrLogTruncated
+	}
+	i := int(after + 1 - db.recent[0].Seq)
+	end := min(len(db.recent), i+maxLogBatch)
+	batch.Entries = append(batch.Entries, db.recent[i:end]...)
+	return batch, nil
+}
+
+// WaitForLog blocks until a transaction after the one numbered after has
+// been committed, the log has been reset, or ctx is done.
+func (db *DB) WaitForLog(ctx context.Context, after uint64) error {
+	db.mu.RLock()
+	seq, committed := db.data.LogSeq, db.committed
+	db.mu.RUnlock()
+	if seq > after {
+		return nil
+	}
+
+	select {
+	case <-committed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LoadSnapshot replaces the contents of a replica with a snapshot from its
+// leader.
+func (db *DB) LoadSnapshot(snapshot ReplicaSnapshot) error {
+	data := snapshot.Data
+	if data.SchemaVersion != latestSchemaVersion() {
+		return errors.New("leader is on a different schema version")
+	}
+	if data.Chirps == nil {
+		data.Chirps = map[int]Chirp{}
+	}
+	if data.Users == nil {
+		data.Users = map[int]User{}
+	}
+	if data.RefreshTokens == nil {
+		data.RefreshTokens = map[string]RefreshToken{}
+	}
+	if data.Likes == nil {
+		data.Likes = map[int]Like{}
+	}
+	data.buildIndexes()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.data = &data
+	db.resetLog()
+	db.epoch = snapshot.Epoch
+	return nil
+}
+
+// ApplyLog applies transactions from the leader to a replica. Entries it
+// already has are skipped; a gap fails with ErrLogTruncated.
+func (db *DB) ApplyLog(entries []LogEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Seq <= db.data.LogSeq {
+			continue
+		}
+		if entry.Seq != db.data.LogSeq+1 {
+			return ErrLogTruncated
+		}
+		tx := &Tx{data: db.data, writable: true}
+		for _, m := range entry.Mutations {
+			err := tx.applyMutation(m)
+			if err != nil {
+				tx.rollback()
+				return err
+			}
+		}
+		db.data.LogSeq = entry.Seq
+		db.recordLog(entry)
+		db.events.publish(tx.events)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package database
+
+// Conversation is a thread of replies, cut off at a depth.
+type Conversation struct {
+	// Chirps holds the root of the thread followed by the replies above
+	// the cut-off in ascending ID order, deleted ones included.
+	Chirps []Chirp
+	// MoreReplies counts the replies left out below each chirp at the
+	// cut-off. A deleted reply only counts if it has replies of its own,
+	// which might still be there.
+	MoreReplies map[int]int
+}
+
+func (db *DB) GetReplies(id int, by SortKey, order SortOrder) ([]Chirp, error) {
+	var chirps []Chirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		chirps, err = tx.GetReplies(id, by, order)
+		return err
+	})
+	return chirps, err
+}
+
+func (db *DB) GetConversation(id, maxDepth int) (Conversation, error) {
+	var conversation Conversation
+	err := db.View(func(tx *Tx) error {
+		var err error
+		conversation, err = tx.GetConversation(id, maxDepth)
+		return err
+	})
+	return conversation, err
+}
+
+// GetReplies returns the chirps replying to the chirp with the given ID.
+// It fails with ErrNotExist if that chirp has never existed or has been
+// purged, but not if it is only deleted.
+func (tx *Tx) GetReplies(id int, by SortKey, order SortOrder) ([]Chirp, error) {
+	if _, ok := tx.data.Chirps[id]; !ok {
+		return nil, ErrNotExist
+	}
+	ids := tx.data.idx.repliesTo[id]
+	chirps := make([]Chirp, 0, len(ids))
+	for _, replyID := range ids {
+		chirp := tx.data.Chirps[replyID]
+		if !chirp.Deleted() {
+			chirps = append(chirps, chirp)
+		}
+	}
+	SortChirps(chirps, by, order)
+	return chirps, nil
+}
+
+// GetConversation returns the root of the thread the chirp with the given
+// ID belongs to, followed by the replies up to maxDepth levels below it.
+// Deleted chirps are included, for callers to show in place of what they
+// replied to.
+func (tx *Tx) GetConversation(id, maxDepth int) (Conversation, error) {
+	chirp, ok := tx.data.Chirps[id]
+	if !ok {
+		return Conversation{}, ErrNotExist
+	}
+
+	// Replies only ever point at older chirps, but guard against loops in
+	// data that has been edited by hand.
+	seen := map[int]bool{id: true}
+	for chirp.InReplyTo != 0 && !seen[chirp.InReplyTo] {
+		parent, ok := tx.data.Chirps[chirp.InReplyTo]
+		if !ok {
+			break
+		}
+		seen[parent.ID] = true
+		chirp = parent
+	}
+
+	conversation := Conversation{Chirps: []Chirp{chirp}, MoreReplies: map[int]int{}}
+	seen = map[int]bool{chirp.ID: true}
+	level := []int{chirp.ID}
+	for depth := 0; depth < maxDepth && len(level) > 0; depth++ {
+		next := []int{}
+		for _, parentID := range level {
+			for _, replyID := range tx.data.idx.repliesTo[parentID] {
+				if !seen[replyID] {
+					seen[replyID] = true
+					conversation.Chirps = append(conversation.Chirps, tx.data.Chirps[replyID])
+					next = append(next, replyID)
+				}
+			}
+		}
+		level = next
+	}
+	for _, parentID := range level {
+		n := 0
+		for _, replyID := range tx.data.idx.repliesTo[parentID] {
+			if !tx.data.Chirps[replyID].Deleted() || len(tx.data.idx.repliesTo[replyID]) > 0 {
+				n++
+			}
+		}
+		if n > 0 {
+			conversation.MoreReplies[parentID] = n
+		}
+	}
+	SortChirps(conversation.Chirps[1:], SortByID, SortAsc)
+	return conversation, nil
+}
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MaskedWord is what the profanity filter puts in place of a bad word.
+// Chirps it was put into are marked Masked and left out of the search
+// index.
+const MaskedWord = "****"
+
+// ErrEmptyQuery means a search query had no words to look for.
+var ErrEmptyQuery = errors.New("search query has no words")
+
+// BM25 parameters: how quickly repeating a term stops adding to the score,
+// and how much a long chirp is penalised for having more chances to match.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchResult is a chirp that matched a search and how well it matched.
+// Higher scores are better.
+type SearchResult struct {
+	Chirp Chirp
+	Score float64
+}
+
+// tokenize splits text into lower-cased words made of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchIndex is an inverted index over chirp bodies. It has no locking of
+// its own: DB keeps it in indexes under db.mu, and SQLiteDB guards it with
+// searchMu.
+type searchIndex struct {
+	// postings maps each term to the chirps it appears in, and the
+	// positions it appears at in each, in ascending order.
+	postings map[string]map[int][]int
+	// terms holds the words of each indexed chirp, for removing it and for
+	// its length.
+	terms       map[int][]string
+	totalLength int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: map[string]map[int][]int{},
+		terms:    map[int][]string{},
+	}
+}
+
+// searchable reports whether chirp belongs in the index. Rechirps have no
+// words of their own.
+func searchable(chirp Chirp) bool {
+	return !chirp.Deleted() && chirp.RechirpOf == 0 && !chirp.Masked
+}
+
+// add indexes chirp, replacing what was indexed for its ID before. Chirps
+// that aren't searchable are only removed.
+func (s *searchIndex) add(chirp Chirp) {
+	s.remove(chirp.ID)
+	if !searchable(chirp) {
+		return
+	}
+	terms := tokenize(chirp.Body)
+	for pos, term := range terms {
+		docs, ok := s.postings[term]
+		if !ok {
+			docs = map[int][]int{}
+			s.postings[term] = docs
+		}
+		docs[chirp.ID] = append(docs[chirp.ID], pos)
+	}
+	s.terms[chirp.ID] = terms
+	s.totalLength += len(terms)
+}
+
+func (s *searchIndex) remove(id int) {
+	terms, ok := s.terms[id]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		docs := s.postings[term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(s.postings, term)
+		}
+	}
+	delete(s.terms, id)
+	s.totalLength -= len(terms)
+}
+
+// searchTerm is one word of a query. A prefix term matches every word that
+// starts with it.
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// searchClause is a run of terms that must appear next to each other, in
+// order. Every clause of a query has to match.
+type searchClause []searchTerm
+
+// parseSearchQuery splits a query into clauses. Text in double quotes is
+// one clause, as is each word outside them. A word ending in * is a prefix.
+// Words are tokenised like chirp bodies, so "don't" is the phrase "don t".
+func parseSearchQuery(query string) []searchClause {
+	clauses := []searchClause{}
+	for i, part := range strings.Split(query, `"`) {
+		inQuotes := i%2 == 1
+		var phrase searchClause
+		for _, word := range strings.Fields(part) {
+			terms := tokenize(word)
+			if len(terms) == 0 {
+				continue
+			}
+			clause := make(searchClause, len(terms))
+			for j, term := range terms {
+				clause[j] = searchTerm{text: term}
+			}
+			clause[len(clause)-1].prefix = strings.HasSuffix(word, "*")
+			if inQuotes {
+				phrase = append(phrase, clause...)
+			} else {
+				clauses = append(clauses, clause)
+			}
+		}
+		if len(phrase) > 0 {
+			clauses = append(clauses, phrase)
+		}
+	}
+	return clauses
+}
+
+// positions returns where term appears in each chirp that has it.
+func (s *searchIndex) positions(term searchTerm) map[int][]int {
+	if !term.prefix {
+		return s.postings[term.text]
+	}
+	merged := map[int][]int{}
+	for text, docs := range s.postings {
+		if !strings.HasPrefix(text, term.text) {
+			continue
+		}
+		for id, positions := range docs {
+			merged[id] = append(merged[id], positions...)
+		}
+	}
+	for _, positions := range merged {
+		sort.Ints(positions)
+	}
+	return merged
+}
+
+// match returns how many times clause occurs in each chirp it occurs in.
+func (s *searchIndex) match(clause searchClause) map[int]int {
+	terms := make([]map[int][]int, len(clause))
+	for i, term := range clause {
+		terms[i] = s.positions(term)
+	}
+
+	counts := map[int]int{}
+	for id, starts := range terms[0] {
+		n := 0
+	next:
+		for _, start := range starts {
+			for i := 1; i < len(terms); i++ {
+				positions := terms[i][id]
+				j := sort.SearchInts(positions, start+i)
+				if j == len(positions) || positions[j] != start+i {
+					continue next
+				}
+			}
+			n++
+		}
+		if n > 0 {
+			counts[id] = n
+		}
+	}
+	return counts
+}
+
+// search returns the IDs of the chirps matching every clause of query with
+// their BM25 scores, best first and newest first among equals.
+func (s *searchIndex) search(query string) ([]SearchResult, error) {
+	clauses := parseSearchQuery(query)
+	if len(clauses) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	n := float64(len(s.terms))
+	if n == 0 {
+		return []SearchResult{}, nil
+	}
+	avgLength := float64(s.totalLength) / n
+
+	var scores map[int]float64
+	for _, clause := range clauses {
+		counts := s.match(clause)
+		df := float64(len(counts))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		clauseScores := map[int]float64{}
+		for id, count := range counts {
+			if scores != nil {
+				if _, ok := scores[id]; !ok {
+					continue
+				}
+			}
+			tf := float64(count)
+			length := float64(len(s.terms[id]))
+			clauseScores[id] = scores[id] + idf*tf*(bm25K1+1)/(tf+bm25K1*(1-bm25B+bm25B*length/avgLength))
+		}
+		scores = clauseScores
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, SearchResult{Chirp: Chirp{ID: id}, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Chirp.ID > results[j].Chirp.ID
+	})
+	return results, nil
+}
+
+// SearchChirps returns up to limit chirps matching query, best match first.
+// Each word of the query has to appear in a chirp for it to match; text in
+// double quotes has to appear as a phrase, and a word ending in * matches
+// any word starting with it. Matching ignores case and punctuation.
+func (db *DB) SearchChirps(query string, limit int) ([]SearchResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	results, err := db.data.idx.search.search(query)
+	if err != nil {
+		return nil, err
+	}
+	results = results[:min(len(results), limit)]
+	for i := range results {
+		results[i].Chirp = db.data.Chirps[results[i].Chirp.ID]
+	}
+	return results, nil
+}
+
+// RebuildSearchIndex indexes every chirp from scratch, for both search and
+// hashtags.
+func (db *DB) RebuildSearchIndex() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.data.idx.search = buildSearchIndex(db.data.Chirps)
+	db.data.idx.hashtags = buildHashtagIndex(db.data.Chirps)
+	return nil
+}
+
+func buildSearchIndex(chirps map[int]Chirp) *searchIndex {
+	search := newSearchIndex()
+	for _, chirp := range chirps {
+		search.add(chirp)
+	}
+	return search
+}
+
+// reindexChirp brings the search and hashtag indexes up to date with a
+// chirp that was old, or didn't exist if existed is false.
+func (tx *Tx) reindexChirp(id int, old Chirp, existed bool) {
+	search, hashtags := tx.data.idx.search, tx.data.idx.hashtags
+	if chirp, ok := tx.data.Chirps[id]; ok {
+		search.add(chirp)
+		hashtags.add(chirp)
+	} else {
+		search.remove(id)
+		hashtags.remove(id)
+	}
+	tx.undo = append(tx.undo, func() {
+		search.remove(id)
+		hashtags.remove(id)
+		if existed {
+			search.add(old)
+			hashtags.add(old)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+func (tx *Tx) nextChirpID() int {
+	id := tx.data.Sequences.Chirps + 1
+	tx.setSequence("chirps", id)
+	return id
+}
+
+func (tx *Tx) nextUserID() int {
+	id := tx.data.Sequences.Users + 1
+	tx.setSequence("users", id)
+	return id
+}
+
+// repairIDs fixes databases written before sequences existed, when the next
+// ID was len(collection)+1 and a create after a delete silently replaced an
+// existing record. The replaced records are gone for good; what can be
+// fixed is that every record's ID matches its key and that the sequences
+// start past the highest ID in use. It returns a description of each
+// change it made.
+func repairIDs(data *DBStructure) []string {
+	changes := []string{}
+
+	for _, id := range sortedKeys(data.Chirps) {
+		chirp := data.Chirps[id]
+		if chirp.ID != id {
+			changes = append(changes, fmt.Sprintf("chirp stored under %d had ID %d", id, chirp.ID))
+			chirp.ID = id
+			data.Chirps[id] = chirp
+		}
+	}
+	for _, id := range sortedKeys(data.Users) {
+		user := data.Users[id]
+		if user.ID != id {
+			changes = append(changes, fmt.Sprintf("user stored under %d had ID %d", id, user.ID))
+			user.ID = id
+			data.Users[id] = user
+		}
+	}
+
+	chirpSeq := maxKey(data.Chirps)
+	if chirpSeq > data.Sequences.Chirps {
+		changes = append(changes, fmt.Sprintf("chirp sequence %d -> %d", data.Sequences.Chirps, chirpSeq))
+		data.Sequences.Chirps = chirpSeq
+	}
+	userSeq := maxKey(data.Users)
+	if userSeq > data.Sequences.Users {
+		changes = append(changes, fmt.Sprintf("user sequence %d -> %d", data.Sequences.Users, userSeq))
+		data.Sequences.Users = userSeq
+	}
+
+	return changes
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func maxKey[V any](m map[int]V) int {
+	max := 0
+	for k := range m {
+		if k > max {
+			max = k
+		}
+	}
+	return max
+}
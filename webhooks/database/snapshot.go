@@ -0,0 +1,134 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+)
+
+const backupFormat = "chirpy-backup"
+
+// backupFile is the on-disk format of a backup: a snapshot of the whole
+// database together with what's needed to validate it before restoring.
+type backupFile struct {
+	Format        string          `json:"format"`
+	SchemaVersion int             `json:"schema_version"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Checksum      string          `json:"checksum"`
+	Data          json.RawMessage `json:"data"`
+}
+
+func (db *DB) Snapshot() (DBStructure, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return DBStructure{
+		SchemaVersion: db.data.SchemaVersion,
+		Chirps:        maps.Clone(db.data.Chirps),
+		Users:         maps.Clone(db.data.Users),
+		RefreshTokens: maps.Clone(db.data.RefreshTokens),
+		Likes:         maps.Clone(db.data.Likes),
+		Sequences:     db.data.Sequences,
+	}, nil
+}
+
+func (db *DB) Restore(data DBStructure) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Keep counting events from where we were, so that subscribers
+	// don't see sequence numbers go backwards.
+	data.LogSeq = 0
+	data.Events, data.EventSeq = db.data.Events, db.data.EventSeq
+	data.buildIndexes()
+	err := db.writeDB(data)
+	if err != nil {
+		return err
+	}
+	if db.wal != nil {
+		err := db.wal.f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		db.wal.size = 0
+	}
+	db.dirty.Store(false)
+	db.data = &data
+	db.resetLog()
+	return nil
+}
+
+// WriteBackup writes a checksummed snapshot of store to w.
+func WriteBackup(w io.Writer, store Store) error {
+	data, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	dat, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(dat)
+
+	return json.NewEncoder(w).Encode(backupFile{
+		Format:        backupFormat,
+		SchemaVersion: data.SchemaVersion,
+		CreatedAt:     time.Now().UTC(),
+		Checksum:      "sha256:" + hex.EncodeToString(sum[:]),
+		Data:          dat,
+	})
+}
+
+// ReadBackup reads and validates a backup written by WriteBackup, migrating
+// it to the current schema version if it is older.
+func ReadBackup(r io.Reader) (DBStructure, error) {
+	backup := backupFile{}
+	err := json.NewDecoder(r).Decode(&backup)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("not a backup file: %w", err)
+	}
+	if backup.Format != backupFormat {
+		return DBStructure{}, fmt.Errorf("not a backup file: format is %q", backup.Format)
+	}
+	if backup.SchemaVersion > latestSchemaVersion() {
+		return DBStructure{}, fmt.Errorf("backup schema version %d is newer than this binary supports (%d)", backup.SchemaVersion, latestSchemaVersion())
+	}
+
+	sum := sha256.Sum256(backup.Data)
+	if backup.Checksum != "sha256:"+hex.EncodeToString(sum[:]) {
+		return DBStructure{}, errors.New("backup checksum doesn't match its contents")
+	}
+
+	data := DBStructure{}
+	err = json.Unmarshal(backup.Data, &data)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	if data.SchemaVersion != backup.SchemaVersion {
+		return DBStructure{}, fmt.Errorf("backup header says schema version %d but data has %d", backup.SchemaVersion, data.SchemaVersion)
+	}
+	_, err = applyMigrations(&data)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	return data, nil
+}
+
+// WriteBackupFile writes a backup of store to path atomically.
+func WriteBackupFile(path string, store Store) error {
+	buf := &bytes.Buffer{}
+	err := WriteBackup(buf, store)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, "", buf.Bytes())
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLiteDB is a Store backed by a SQLite database file.
+type SQLiteDB struct {
+	conn *sql.DB
+
+	// writeMu serialises the transactions that emit events, so that they
+	// are published and indexed in the order they were committed.
+	writeMu *sync.Mutex
+	events  *eventHub
+
+	// search is the full-text index over chirp bodies and hashtags the
+	// index of the tags in them. They live in memory and are rebuilt from
+	// the chirps table on open.
+	searchMu *sync.RWMutex
+	search   *searchIndex
+	hashtags *hashtagIndex
+}
+
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	err = migrateSQLite(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	db := &SQLiteDB{
+		conn:     conn,
+		writeMu:  &sync.Mutex{},
+		events:   newEventHub(),
+		searchMu: &sync.RWMutex{},
+	}
+	err = db.RebuildSearchIndex()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
+
+func (db *SQLiteDB) ResetDB() error {
+	_, err := db.conn.Exec(`
+DELETE FROM refresh_tokens;
+DELETE FROM likes;
+DELETE FROM chirp_revisions;
+DELETE FROM chirps;
+DELETE FROM users;
+DELETE FROM events;
+DELETE FROM sqlite_sequence;
+`)
+	if err != nil {
+		return err
+	}
+	return db.RebuildSearchIndex()
+}
+
+func (db *SQLiteDB) SaveRefreshToken(userID int, token string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES (?, ?, ?)`,
+		token, userID, time.Now().Add(time.Hour).UTC(),
+	)
+	return err
+}
+
+func (db *SQLiteDB) RevokeRefreshToken(token string) error {
+	return db.update(func(tx *sql.Tx) ([]Change, error) {
+		var userID int
+		err := tx.QueryRow(
+			`DELETE FROM refresh_tokens WHERE token = ? RETURNING user_id`, token,
+		).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []Change{TokenRevoked{UserID: userID}}, nil
+	})
+}
+
+func (db *SQLiteDB) PurgeExpiredRefreshTokens(expiredBefore time.Time) (int, error) {
+	res, err := db.conn.Exec(`DELETE FROM refresh_tokens WHERE expires_at < ?`, expiredBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (db *SQLiteDB) UserForRefreshToken(token string) (User, error) {
+	var userID int
+	var expiresAt time.Time
+	err := db.conn.QueryRow(
+		`SELECT user_id, expires_at FROM refresh_tokens WHERE token = ?`, token,
+	).Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotExist
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if expiresAt.Before(time.Now()) {
+		return User{}, ErrNotExist
+	}
+
+	return db.GetUser(userID)
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+func isPrimaryKeyViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
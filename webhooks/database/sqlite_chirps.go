@@ -0,0 +1,421 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// chirpColumns is the column list scanChirp expects.
+const chirpColumns = `id, author_id, body, masked, in_reply_to, rechirp_of, quote_of, created_at, updated_at, deleted_at, deleted_by, version`
+
+// sqlVisible matches the chirps GetChirp returns: not deleted, and not
+// rechirps of a deleted chirp.
+const sqlVisible = `deleted_at IS NULL
+	AND (rechirp_of IS NULL OR rechirp_of IN (SELECT id FROM chirps AS originals WHERE originals.deleted_at IS NULL))`
+
+// sqlQuerier and sqlExecer are satisfied by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func scanChirp(row interface{ Scan(dest ...any) error }) (Chirp, error) {
+	chirp := Chirp{}
+	var inReplyTo, rechirpOf, quoteOf sql.NullInt64
+	var deletedAt sql.NullTime
+	var deletedBy sql.NullInt64
+	err := row.Scan(
+		&chirp.ID, &chirp.AuthorID, &chirp.Body, &chirp.Masked, &inReplyTo, &rechirpOf, &quoteOf,
+		&chirp.CreatedAt, &chirp.UpdatedAt, &deletedAt, &deletedBy, &chirp.Version,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.InReplyTo = int(inReplyTo.Int64)
+	chirp.RechirpOf = int(rechirpOf.Int64)
+	chirp.QuoteOf = int(quoteOf.Int64)
+	chirp.CreatedAt = chirp.CreatedAt.UTC()
+	chirp.UpdatedAt = chirp.UpdatedAt.UTC()
+	if deletedAt.Valid {
+		chirp.DeletedAt = &deletedAt.Time
+		chirp.DeletedBy = int(deletedBy.Int64)
+	}
+	return chirp, nil
+}
+
+func queryChirps(q sqlQuerier, query string, args ...any) ([]Chirp, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// insertChirp stores chirp and its history and returns its ID. Unless keepID
+// is set, the ID in chirp is ignored and a new one is assigned.
+func insertChirp(e sqlExecer, chirp Chirp, keepID bool) (int, error) {
+	var id any
+	if keepID {
+		id = chirp.ID
+	}
+	var inReplyTo, rechirpOf, quoteOf any
+	if chirp.InReplyTo != 0 {
+		inReplyTo = chirp.InReplyTo
+	}
+	if chirp.RechirpOf != 0 {
+		rechirpOf = chirp.RechirpOf
+	}
+	if chirp.QuoteOf != 0 {
+		quoteOf = chirp.QuoteOf
+	}
+	var deletedAt any
+	var deletedBy any
+	if chirp.DeletedAt != nil {
+		deletedAt = chirp.DeletedAt.UTC()
+		deletedBy = chirp.DeletedBy
+	}
+	version := chirp.Version
+	if version == 0 {
+		version = 1
+	}
+	createdAt, updatedAt := chirp.CreatedAt, chirp.UpdatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	if updatedAt.IsZero() {
+		updatedAt = createdAt
+	}
+	res, err := e.Exec(
+		`INSERT INTO chirps (`+chirpColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, chirp.AuthorID, chirp.Body, chirp.Masked, inReplyTo, rechirpOf, quoteOf,
+		createdAt.UTC(), updatedAt.UTC(), deletedAt, deletedBy, version,
+	)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for i, revision := range chirp.History {
+		_, err := e.Exec(
+			`INSERT INTO chirp_revisions (chirp_id, revision, body, created_at) VALUES (?, ?, ?, ?)`,
+			newID, i+1, revision.Body, revision.CreatedAt.UTC(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(newID), nil
+}
+
+// queryChirpHistory returns the earlier bodies of the chirp with the given
+// ID, oldest first.
+func queryChirpHistory(q sqlQuerier, id int) ([]ChirpRevision, error) {
+	rows, err := q.Query(
+		`SELECT body, created_at FROM chirp_revisions WHERE chirp_id = ? ORDER BY revision`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []ChirpRevision{}
+	for rows.Next() {
+		revision := ChirpRevision{}
+		err := rows.Scan(&revision.Body, &revision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revision.CreatedAt = revision.CreatedAt.UTC()
+		history = append(history, revision)
+	}
+	return history, rows.Err()
+}
+
+func (db *SQLiteDB) CreateChirp(body string, masked bool, authorID, inReplyTo int) (Chirp, error) {
+	now := time.Now().UTC()
+	chirp := Chirp{
+		Body:      body,
+		Masked:    masked,
+		AuthorID:  authorID,
+		InReplyTo: inReplyTo,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	err := db.updateThen(func(tx *sql.Tx) ([]Change, error) {
+		if inReplyTo != 0 {
+			// Replying to a rechirp replies to what it shares.
+			err := tx.QueryRow(
+				`SELECT COALESCE(rechirp_of, id) FROM chirps WHERE id = ? AND `+sqlVisible, inReplyTo,
+			).Scan(&chirp.InReplyTo)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrMissingReference
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		id, err := insertChirp(tx, chirp, false)
+		if err != nil {
+			return nil, err
+		}
+		chirp.ID = id
+		return []Change{ChirpCreated{Chirp: chirp}}, nil
+	}, func() {
+		db.indexChirp(chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirps(by SortKey, order SortOrder) ([]Chirp, error) {
+	return queryChirps(db.conn,
+		`SELECT `+chirpColumns+` FROM chirps WHERE `+sqlVisible+` ORDER BY `+sqlOrderBy(by, order),
+	)
+}
+
+func (db *SQLiteDB) GetChirpsByAuthor(authorID int, by SortKey, order SortOrder) ([]Chirp, error) {
+	return queryChirps(db.conn,
+		`SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? AND `+sqlVisible+` ORDER BY `+sqlOrderBy(by, order),
+		authorID,
+	)
+}
+
+func sqlOrderBy(by SortKey, order SortOrder) string {
+	direction := "ASC"
+	if order == SortDesc {
+		direction = "DESC"
+	}
+	switch by {
+	case SortByCreatedAt:
+		return "created_at " + direction + ", id " + direction
+	case SortByUpdatedAt:
+		return "updated_at " + direction + ", id " + direction
+	}
+	return "id " + direction
+}
+
+func (db *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow(
+		`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND `+sqlVisible, id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrNotExist
+	}
+	return chirp, err
+}
+
+func (db *SQLiteDB) GetDeletedChirp(id int) (Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow(
+		`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NOT NULL`, id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrNotExist
+	}
+	return chirp, err
+}
+
+func (db *SQLiteDB) GetChirpHistory(id int) ([]ChirpRevision, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := scanChirp(tx.QueryRow(
+		`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND `+sqlVisible, id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	history, err := queryChirpHistory(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return append(history, ChirpRevision{Body: chirp.Body, CreatedAt: chirp.UpdatedAt}), nil
+}
+
+func (db *SQLiteDB) UpdateChirp(id int, body string, masked bool, ifVersion int) (Chirp, error) {
+	var chirp Chirp
+	err := db.updateThen(func(tx *sql.Tx) ([]Change, error) {
+		var err error
+		chirp, err = scanChirp(tx.QueryRow(
+			`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND `+sqlVisible, id,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ifVersion != 0 && chirp.Version != ifVersion {
+			return nil, ErrVersionConflict
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO chirp_revisions (chirp_id, revision, body, created_at)
+			SELECT ?, COUNT(*) + 1, ?, ? FROM chirp_revisions WHERE chirp_id = ?`,
+			id, chirp.Body, chirp.UpdatedAt.UTC(), id,
+		)
+		if err != nil {
+			return nil, err
+		}
+		chirp.Body = body
+		chirp.Masked = masked
+		chirp.UpdatedAt = time.Now().UTC()
+		chirp.Version++
+		_, err = tx.Exec(
+			`UPDATE chirps SET body = ?, masked = ?, updated_at = ?, version = ? WHERE id = ?`,
+			chirp.Body, chirp.Masked, chirp.UpdatedAt, chirp.Version, id,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return []Change{ChirpEdited{ChirpID: id, AuthorID: chirp.AuthorID, Body: body}}, nil
+	}, func() {
+		db.indexChirp(chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) DeleteChirp(id, deletedBy, ifVersion int) error {
+	err := db.updateThen(func(tx *sql.Tx) ([]Change, error) {
+		var authorID int
+		err := tx.QueryRow(
+			`UPDATE chirps SET deleted_at = ?, deleted_by = ?, version = version + 1
+			WHERE id = ? AND `+sqlVisible+` AND (? = 0 OR version = ?)
+			RETURNING author_id`,
+			time.Now().UTC(), deletedBy, id, ifVersion, ifVersion,
+		).Scan(&authorID)
+		if errors.Is(err, sql.ErrNoRows) {
+			if _, err := db.GetChirp(id); err != nil {
+				return nil, err
+			}
+			return nil, ErrVersionConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []Change{ChirpDeleted{ChirpID: id, AuthorID: authorID, DeletedBy: deletedBy}}, nil
+	}, func() {
+		db.unindexChirp(id)
+	})
+	return err
+}
+
+func (db *SQLiteDB) UndeleteChirp(id int) (Chirp, error) {
+	var chirp Chirp
+	err := db.updateThen(func(tx *sql.Tx) ([]Change, error) {
+		var err error
+		chirp, err = scanChirp(tx.QueryRow(
+			`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NOT NULL`, id,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chirp.RechirpOf != 0 {
+			var rechirped bool
+			err := tx.QueryRow(
+				`SELECT EXISTS (SELECT 1 FROM chirps WHERE rechirp_of = ? AND author_id = ? AND deleted_at IS NULL)`,
+				chirp.RechirpOf, chirp.AuthorID,
+			).Scan(&rechirped)
+			if err != nil {
+				return nil, err
+			}
+			if rechirped {
+				return nil, ErrAlreadyExists
+			}
+		}
+		chirp.DeletedAt = nil
+		chirp.DeletedBy = 0
+		chirp.Version++
+		_, err = tx.Exec(
+			`UPDATE chirps SET deleted_at = NULL, deleted_by = NULL, version = ? WHERE id = ?`, chirp.Version, id,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return []Change{ChirpUndeleted{Chirp: chirp}}, nil
+	}, func() {
+		db.indexChirp(chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Tombstones with replies or quotes are kept, and rechirps and likes
+	// go with what they refer to. Purging a reply or quote can leave what it referred
+	// to with none, so go round until nothing changes.
+	const purgeable = `deleted_at IS NOT NULL AND deleted_at < ?
+		AND NOT EXISTS (SELECT 1 FROM chirps AS replies WHERE replies.in_reply_to = chirps.id)
+		AND NOT EXISTS (SELECT 1 FROM chirps AS quotes WHERE quotes.quote_of = chirps.id)`
+	purged := 0
+	for {
+		for _, query := range []string{
+			`DELETE FROM chirp_revisions WHERE chirp_id IN (SELECT id FROM chirps WHERE ` + purgeable + `)`,
+			`DELETE FROM likes WHERE chirp_id IN (SELECT id FROM chirps WHERE ` + purgeable + `)`,
+		} {
+			_, err = tx.Exec(query, deletedBefore.UTC())
+			if err != nil {
+				return 0, err
+			}
+		}
+		n := int64(0)
+		for _, query := range []string{
+			`DELETE FROM chirps WHERE rechirp_of IN (SELECT id FROM chirps WHERE ` + purgeable + `)`,
+			`DELETE FROM chirps WHERE ` + purgeable,
+		} {
+			res, err := tx.Exec(query, deletedBefore.UTC())
+			if err != nil {
+				return 0, err
+			}
+			rows, err := res.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			n += rows
+		}
+		if n == 0 {
+			break
+		}
+		purged += int(n)
+	}
+	return purged, tx.Commit()
+}
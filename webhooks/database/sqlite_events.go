@@ -0,0 +1,138 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// update runs fn in a transaction, stores the changes it returns as events
+// and publishes them once the transaction has committed.
+func (db *SQLiteDB) update(fn func(tx *sql.Tx) ([]Change, error)) error {
+	return db.updateThen(fn, nil)
+}
+
+// updateThen is update with a hook that runs once the transaction has
+// committed, before the next one can start, so that in-memory state such as
+// the search index changes in the same order as the rows.
+func (db *SQLiteDB) updateThen(fn func(tx *sql.Tx) ([]Change, error), afterCommit func()) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changes, err := fn(tx)
+	if err != nil {
+		return err
+	}
+	events, err := insertEvents(tx, changes)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	if afterCommit != nil {
+		afterCommit()
+	}
+	db.events.publish(events)
+	return nil
+}
+
+func insertEvents(tx *sql.Tx, changes []Change) ([]Event, error) {
+	events := make([]Event, 0, len(changes))
+	for _, change := range changes {
+		data, err := json.Marshal(change)
+		if err != nil {
+			return nil, err
+		}
+		e := Event{Time: time.Now().UTC(), Change: change}
+		res, err := tx.Exec(
+			`INSERT INTO events (type, time, data) VALUES (?, ?, ?)`,
+			change.eventType(), e.Time, string(data),
+		)
+		if err != nil {
+			return nil, err
+		}
+		seq, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		e.Seq = uint64(seq)
+		events = append(events, e)
+	}
+	if len(events) > 0 {
+		_, err := tx.Exec(
+			`DELETE FROM events WHERE seq <= ?`, int64(events[len(events)-1].Seq)-maxRetainedEvents,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (db *SQLiteDB) Subscribe(after uint64) (*Subscription, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	lastSeq, err := db.LastEventSeq()
+	if err != nil {
+		return nil, err
+	}
+	if after == 0 {
+		var oldest sql.NullInt64
+		err := db.conn.QueryRow(`SELECT MIN(seq) FROM events`).Scan(&oldest)
+		if err != nil {
+			return nil, err
+		}
+		after = lastSeq
+		if oldest.Valid {
+			after = uint64(oldest.Int64) - 1
+		}
+	}
+
+	rows, err := db.conn.Query(`SELECT seq, type, time, data FROM events WHERE seq > ? ORDER BY seq`, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var eventType, data string
+		err := rows.Scan(&e.Seq, &eventType, &e.Time, &data)
+		if err != nil {
+			return nil, err
+		}
+		e.Change, err = decodeChange(eventType, []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	backlog, err := eventsAfter(events, lastSeq, after)
+	if err != nil {
+		return nil, err
+	}
+	return db.events.subscribe(backlog), nil
+}
+
+func (db *SQLiteDB) LastEventSeq() (uint64, error) {
+	var seq uint64
+	err := db.conn.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name = 'events'`).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return seq, err
+}
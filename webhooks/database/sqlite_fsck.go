@@ -0,0 +1,231 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+func (db *SQLiteDB) Fsck(repair bool) (FsckReport, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return FsckReport{}, err
+	}
+	defer tx.Rollback()
+
+	report := FsckReport{Problems: []FsckProblem{}}
+	for table, n := range map[string]*int{
+		"users":          &report.Users,
+		"chirps":         &report.Chirps,
+		"refresh_tokens": &report.RefreshTokens,
+		"likes":          &report.Likes,
+	} {
+		err := tx.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(n)
+		if err != nil {
+			return FsckReport{}, err
+		}
+	}
+
+	type duplicate struct {
+		id      int
+		email   string
+		ownerID int
+	}
+	duplicates := []duplicate{}
+	rows, err := tx.Query(`
+SELECT u.id, u.email, (SELECT MIN(id) FROM users WHERE email = u.email)
+FROM users u
+WHERE u.id > (SELECT MIN(id) FROM users WHERE email = u.email)
+ORDER BY u.email, u.id`)
+	if err != nil {
+		return FsckReport{}, err
+	}
+	for rows.Next() {
+		d := duplicate{}
+		if err := rows.Scan(&d.id, &d.email, &d.ownerID); err != nil {
+			rows.Close()
+			return FsckReport{}, err
+		}
+		duplicates = append(duplicates, d)
+	}
+	rows.Close()
+	for _, d := range duplicates {
+		report.add(CollectionUsers, strconv.Itoa(d.id), "email %q is also used by user %d", d.email, d.ownerID)
+	}
+
+	orphans, err := queryOrphans(tx,
+		`SELECT id, author_id FROM chirps WHERE author_id NOT IN (SELECT id FROM users) ORDER BY id`,
+	)
+	if err != nil {
+		return FsckReport{}, err
+	}
+	for _, o := range orphans {
+		p := report.add(CollectionChirps, o.key, "author %d doesn't exist", o.refID)
+		if repair {
+			_, err := tx.Exec(`DELETE FROM chirp_revisions WHERE chirp_id = ?`, o.key)
+			if err != nil {
+				return FsckReport{}, err
+			}
+			_, err = tx.Exec(`DELETE FROM chirps WHERE id = ?`, o.key)
+			if err != nil {
+				return FsckReport{}, err
+			}
+			p.Repaired = true
+		}
+	}
+
+	// Checked after orphaned chirps have been removed, in case a reply's
+	// parent or what a chirp shares or quotes was one of them.
+	orphans, err = queryOrphans(tx,
+		`SELECT id, rechirp_of FROM chirps WHERE rechirp_of NOT IN (SELECT id FROM chirps) ORDER BY id`,
+	)
+	if err != nil {
+		return FsckReport{}, err
+	}
+	for _, o := range orphans {
+		// A rechirp is nothing without what it shares.
+		p := report.add(CollectionChirps, o.key, "rechirps chirp %d, which doesn't exist", o.refID)
+		if repair {
+			_, err := tx.Exec(`DELETE FROM chirp_revisions WHERE chirp_id = ?`, o.key)
+			if err != nil {
+				return FsckReport{}, err
+			}
+			_, err = tx.Exec(`DELETE FROM chirps WHERE id = ?`, o.key)
+			if err != nil {
+				return FsckReport{}, err
+			}
+			p.Repaired = true
+		}
+	}
+
+	orphans, err = queryOrphans(tx,
+		`SELECT id, in_reply_to FROM chirps WHERE in_reply_to NOT IN (SELECT id FROM chirps) ORDER BY id`,
+	)
+	if err != nil {
+		return FsckReport{}, err
+	}
+	for _, o := range orphans {
+		p := report.add(CollectionChirps, o.key, "replies to chirp %d, which doesn't exist", o.refID)
+		if repair {
+			_, err := tx.Exec(`UPDATE chirps SET in_reply_to = NULL WHERE id = ?`, o.key)
+			if err != nil {
+				return FsckReport{}, err
+			}
+			p.Repaired = true
+		}
+	}
+
+	orphans, err = queryOrphans(tx,
+		`SELECT id, quote_of FROM chirps WHERE quote_of NOT IN (SELECT id FROM chirps) ORDER BY id`,
+	)
+	if err != nil {
+		return FsckReport{}, err
+	}
+	for _, o := range orphans {
+		p := report.add(CollectionChirps, o.key, "quotes chirp %d, which doesn't exist", o.refID)
+		if repair {
+			_, err := tx.Exec(`UPDATE chirps SET quote_of = NULL WHERE id = ?`, o.key)
+			if err != nil {
+				return FsckReport{}, err
+			}
+			p.Repaired = true
+		}
+	}
+
+	// Duplicate likes can't happen here: the unique index prevents them.
+	for _, check := range []struct {
+		query   string
+		problem string
+	}{
+		{`SELECT id, user_id FROM likes WHERE user_id NOT IN (SELECT id FROM users) ORDER BY id`, "user %d doesn't exist"},
+		{`SELECT id, chirp_id FROM likes WHERE chirp_id NOT IN (SELECT id FROM chirps) ORDER BY id`, "chirp %d doesn't exist"},
+	} {
+		orphans, err = queryOrphans(tx, check.query)
+		if err != nil {
+			return FsckReport{}, err
+		}
+		for _, o := range orphans {
+			p := report.add(CollectionLikes, o.key, check.problem, o.refID)
+			if repair {
+				_, err := tx.Exec(`DELETE FROM likes WHERE id = ?`, o.key)
+				if err != nil {
+					return FsckReport{}, err
+				}
+				p.Repaired = true
+			}
+		}
+	}
+
+	orphans, err = queryOrphans(tx,
+		`SELECT token, user_id FROM refresh_tokens WHERE user_id NOT IN (SELECT id FROM users) ORDER BY token`,
+	)
+	if err != nil {
+		return FsckReport{}, err
+	}
+	for _, o := range orphans {
+		p := report.add(CollectionRefreshTokens, o.key[:min(len(o.key), 8)]+"…", "user %d doesn't exist", o.refID)
+		if repair {
+			_, err := tx.Exec(`DELETE FROM refresh_tokens WHERE token = ?`, o.key)
+			if err != nil {
+				return FsckReport{}, err
+			}
+			p.Repaired = true
+		}
+	}
+
+	for _, table := range []string{"chirps", "users", "likes"} {
+		current, err := sqliteSequence(tx, table)
+		if err != nil {
+			return FsckReport{}, err
+		}
+		var maxID sql.NullInt64
+		err = tx.QueryRow(`SELECT MAX(id) FROM ` + table).Scan(&maxID)
+		if err != nil {
+			return FsckReport{}, err
+		}
+		if int64(current) >= maxID.Int64 {
+			continue
+		}
+		p := report.add("sequences", table, "at %d but IDs up to %d are in use", current, maxID.Int64)
+		if repair {
+			err := setSQLiteSequence(tx, table, int(maxID.Int64))
+			if err != nil {
+				return FsckReport{}, err
+			}
+			p.Repaired = true
+		}
+	}
+
+	if !repair {
+		return report, nil
+	}
+	err = tx.Commit()
+	if err != nil {
+		return FsckReport{}, fmt.Errorf("couldn't save repairs: %w", err)
+	}
+	return report, db.RebuildSearchIndex()
+}
+
+// orphan is a record that refers to another that doesn't exist.
+type orphan struct {
+	key   string
+	refID int
+}
+
+func queryOrphans(tx *sql.Tx, query string) ([]orphan, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orphans := []orphan{}
+	for rows.Next() {
+		o := orphan{}
+		if err := rows.Scan(&o.key, &o.refID); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, rows.Err()
+}
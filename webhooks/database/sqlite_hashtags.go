@@ -0,0 +1,34 @@
+package database
+
+import "time"
+
+func (db *SQLiteDB) GetChirpsByHashtag(tag string, by SortKey, order SortOrder) ([]Chirp, error) {
+	tag, ok := NormalizeHashtag(tag)
+	if !ok {
+		return nil, ErrInvalidHashtag
+	}
+	db.searchMu.RLock()
+	ids := db.hashtags.ids(tag)
+	db.searchMu.RUnlock()
+
+	chirps := make([]Chirp, 0, len(ids))
+	err := inBatches(ids, func(in string, args []any) error {
+		found, err := queryChirps(db.conn, `SELECT `+chirpColumns+` FROM chirps WHERE id IN `+in+` AND `+sqlVisible, args...)
+		if err != nil {
+			return err
+		}
+		chirps = append(chirps, found...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	SortChirps(chirps, by, order)
+	return chirps, nil
+}
+
+func (db *SQLiteDB) GetTrendingHashtags(window time.Duration, limit int) ([]TrendingHashtag, error) {
+	db.searchMu.RLock()
+	defer db.searchMu.RUnlock()
+	return db.hashtags.trending(time.Now(), window, limit), nil
+}
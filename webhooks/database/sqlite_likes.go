@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// likeColumns is the column list scanLike expects.
+const likeColumns = `id, chirp_id, user_id, created_at`
+
+func scanLike(row interface{ Scan(dest ...any) error }) (Like, error) {
+	like := Like{}
+	err := row.Scan(&like.ID, &like.ChirpID, &like.UserID, &like.CreatedAt)
+	if err != nil {
+		return Like{}, err
+	}
+	like.CreatedAt = like.CreatedAt.UTC()
+	return like, nil
+}
+
+func queryLikes(q sqlQuerier, query string, args ...any) ([]Like, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	likes := []Like{}
+	for rows.Next() {
+		like, err := scanLike(rows)
+		if err != nil {
+			return nil, err
+		}
+		likes = append(likes, like)
+	}
+	return likes, rows.Err()
+}
+
+// insertLike stores like and returns its ID. Unless keepID is set, the ID
+// in like is ignored and a new one is assigned.
+func insertLike(e sqlExecer, like Like, keepID bool) (int, error) {
+	var id any
+	if keepID {
+		id = like.ID
+	}
+	createdAt := like.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	res, err := e.Exec(
+		`INSERT INTO likes (`+likeColumns+`) VALUES (?, ?, ?, ?)`,
+		id, like.ChirpID, like.UserID, createdAt.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := res.LastInsertId()
+	return int(newID), err
+}
+
+func (db *SQLiteDB) LikeChirp(chirpID, userID int) (Like, error) {
+	like := Like{UserID: userID, CreatedAt: time.Now().UTC()}
+	err := db.update(func(tx *sql.Tx) ([]Change, error) {
+		err := tx.QueryRow(
+			`SELECT COALESCE(rechirp_of, id) FROM chirps WHERE id = ? AND `+sqlVisible, chirpID,
+		).Scan(&like.ChirpID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		existing, err := scanLike(tx.QueryRow(
+			`SELECT `+likeColumns+` FROM likes WHERE chirp_id = ? AND user_id = ?`, like.ChirpID, userID,
+		))
+		if err == nil {
+			like = existing
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		like.ID, err = insertLike(tx, like, false)
+		if err != nil {
+			return nil, err
+		}
+		return []Change{ChirpLiked{ChirpID: like.ChirpID, UserID: userID}}, nil
+	})
+	if err != nil {
+		return Like{}, err
+	}
+	return like, nil
+}
+
+func (db *SQLiteDB) UnlikeChirp(chirpID, userID int) (int, error) {
+	err := db.update(func(tx *sql.Tx) ([]Change, error) {
+		// Likes of a deleted chirp are hidden, but can still be taken back.
+		err := tx.QueryRow(`SELECT COALESCE(rechirp_of, id) FROM chirps WHERE id = ?`, chirpID).Scan(&chirpID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		res, err := tx.Exec(`DELETE FROM likes WHERE chirp_id = ? AND user_id = ?`, chirpID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, nil
+		}
+		return []Change{ChirpUnliked{ChirpID: chirpID, UserID: userID}}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return chirpID, nil
+}
+
+func (db *SQLiteDB) GetChirpLikes(chirpID, before, limit int) (LikePage, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return LikePage{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`SELECT COALESCE(rechirp_of, id) FROM chirps WHERE id = ? AND `+sqlVisible, chirpID,
+	).Scan(&chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LikePage{}, ErrNotExist
+	}
+	if err != nil {
+		return LikePage{}, err
+	}
+	likes, err := queryLikes(tx,
+		`SELECT `+likeColumns+` FROM likes WHERE chirp_id = ? AND (? = 0 OR id < ?) ORDER BY id DESC LIMIT ?`,
+		chirpID, before, before, limit+1,
+	)
+	if err != nil {
+		return LikePage{}, err
+	}
+	return sqlLikePage(likes, limit), nil
+}
+
+func (db *SQLiteDB) GetUserLikes(userID, before, limit int) (LikePage, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return LikePage{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists)
+	if err != nil {
+		return LikePage{}, err
+	}
+	if !exists {
+		return LikePage{}, ErrNotExist
+	}
+	likes, err := queryLikes(tx,
+		`SELECT `+likeColumns+` FROM likes
+		WHERE user_id = ? AND (? = 0 OR id < ?) AND chirp_id IN (SELECT id FROM chirps WHERE `+sqlVisible+`)
+		ORDER BY id DESC LIMIT ?`,
+		userID, before, before, limit+1,
+	)
+	if err != nil {
+		return LikePage{}, err
+	}
+	return sqlLikePage(likes, limit), nil
+}
+
+// sqlLikePage makes a page from up to limit+1 likes, the extra one showing
+// that there are more.
+func sqlLikePage(likes []Like, limit int) LikePage {
+	if len(likes) <= limit {
+		return LikePage{Likes: likes}
+	}
+	likes = likes[:limit]
+	return LikePage{Likes: likes, NextBefore: likes[limit-1].ID}
+}
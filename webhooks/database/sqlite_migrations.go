@@ -0,0 +1,156 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// sqliteMigrations are applied in order on startup. Never edit a migration
+// that has shipped; append a new one instead.
+var sqliteMigrations = []string{
+	// 1: initial schema
+	`
+CREATE TABLE users (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	email           TEXT    NOT NULL,
+	hashed_password TEXT    NOT NULL,
+	is_chirpy_red   INTEGER NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX idx_users_email ON users(email);
+
+CREATE TABLE chirps (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id INTEGER NOT NULL,
+	body      TEXT    NOT NULL
+);
+CREATE INDEX idx_chirps_author_id ON chirps(author_id);
+
+CREATE TABLE refresh_tokens (
+	token      TEXT      PRIMARY KEY,
+	user_id    INTEGER   NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+`,
+	// 2: soft-deleted chirps
+	`
+ALTER TABLE chirps ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE chirps ADD COLUMN deleted_by INTEGER;
+CREATE INDEX idx_chirps_deleted_at ON chirps(deleted_at) WHERE deleted_at IS NOT NULL;
+`,
+	// 3: record versions
+	`
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE chirps ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+`,
+	// 4: change events
+	`
+CREATE TABLE events (
+	seq  INTEGER   PRIMARY KEY AUTOINCREMENT,
+	type TEXT      NOT NULL,
+	time TIMESTAMP NOT NULL,
+	data TEXT      NOT NULL
+);
+`,
+	// 5: chirp timestamps and edit history. When older chirps were posted
+	// wasn't recorded, so they are treated as posted now.
+	`
+ALTER TABLE chirps ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE chirps ADD COLUMN updated_at TIMESTAMP;
+UPDATE chirps SET created_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP;
+CREATE INDEX idx_chirps_created_at ON chirps(created_at);
+CREATE INDEX idx_chirps_updated_at ON chirps(updated_at);
+
+CREATE TABLE chirp_revisions (
+	chirp_id   INTEGER   NOT NULL,
+	revision   INTEGER   NOT NULL,
+	body       TEXT      NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (chirp_id, revision)
+);
+`,
+	// 6: replies
+	`
+ALTER TABLE chirps ADD COLUMN in_reply_to INTEGER;
+CREATE INDEX idx_chirps_in_reply_to ON chirps(in_reply_to) WHERE in_reply_to IS NOT NULL;
+`,
+	// 7: rechirps and quotes
+	`
+ALTER TABLE chirps ADD COLUMN rechirp_of INTEGER;
+ALTER TABLE chirps ADD COLUMN quote_of INTEGER;
+CREATE INDEX idx_chirps_rechirp_of ON chirps(rechirp_of) WHERE rechirp_of IS NOT NULL;
+CREATE INDEX idx_chirps_quote_of ON chirps(quote_of) WHERE quote_of IS NOT NULL;
+`,
+	// 8: likes
+	`
+CREATE TABLE likes (
+	id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+	chirp_id   INTEGER   NOT NULL,
+	user_id    INTEGER   NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE UNIQUE INDEX idx_likes_chirp_id_user_id ON likes(chirp_id, user_id);
+CREATE INDEX idx_likes_chirp_id_id ON likes(chirp_id, id);
+CREATE INDEX idx_likes_user_id_id ON likes(user_id, id);
+`,
+	// 9: refresh token expiry in UTC. Imports used to store it in local
+	// time, which doesn't compare correctly as text.
+	`
+UPDATE refresh_tokens
+SET expires_at = strftime('%Y-%m-%d %H:%M:%f', expires_at) || '+00:00'
+WHERE expires_at NOT LIKE '%+00:00';
+`,
+	// 10: masked chirps. Older chirps are marked masked if their body
+	// contains the mask, as search used to decide.
+	`
+ALTER TABLE chirps ADD COLUMN masked INTEGER NOT NULL DEFAULT 0;
+UPDATE chirps SET masked = 1 WHERE instr(body, '****') > 0;
+`,
+}
+
+func migrateSQLite(conn *sql.DB) error {
+	_, err := conn.Exec(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER   PRIMARY KEY,
+	applied_at TIMESTAMP NOT NULL
+)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, len(sqliteMigrations))
+	}
+
+	for i := current; i < len(sqliteMigrations); i++ {
+		version := i + 1
+		tx, err := conn.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		_, err = tx.Exec(
+			`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+			version, time.Now().UTC(),
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Applied sqlite migration %d", version)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// sqlBatchSize keeps IN lists well under SQLite's limit on bound
+// parameters.
+const sqlBatchSize = 500
+
+func (db *SQLiteDB) CreateRechirp(chirpID, userID int) (Chirp, error) {
+	now := time.Now().UTC()
+	chirp := Chirp{
+		AuthorID:  userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	err := db.update(func(tx *sql.Tx) ([]Change, error) {
+		err := tx.QueryRow(
+			`SELECT COALESCE(rechirp_of, id) FROM chirps WHERE id = ? AND `+sqlVisible, chirpID,
+		).Scan(&chirp.RechirpOf)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rechirped bool
+		err = tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM chirps WHERE rechirp_of = ? AND author_id = ? AND deleted_at IS NULL)`,
+			chirp.RechirpOf, userID,
+		).Scan(&rechirped)
+		if err != nil {
+			return nil, err
+		}
+		if rechirped {
+			return nil, ErrAlreadyExists
+		}
+		id, err := insertChirp(tx, chirp, false)
+		if err != nil {
+			return nil, err
+		}
+		chirp.ID = id
+		return []Change{ChirpCreated{Chirp: chirp}}, nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) DeleteRechirp(chirpID, userID int) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(tx *sql.Tx) ([]Change, error) {
+		// Rechirps of a deleted chirp are hidden, but can still be undone.
+		var originalID int
+		err := tx.QueryRow(`SELECT COALESCE(rechirp_of, id) FROM chirps WHERE id = ?`, chirpID).Scan(&originalID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		chirp, err = scanChirp(tx.QueryRow(
+			`UPDATE chirps SET deleted_at = ?, deleted_by = ?, version = version + 1
+			WHERE rechirp_of = ? AND author_id = ? AND deleted_at IS NULL
+			RETURNING `+chirpColumns,
+			time.Now().UTC(), userID, originalID, userID,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []Change{ChirpDeleted{ChirpID: chirp.ID, AuthorID: userID, DeletedBy: userID}}, nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) CreateQuote(body string, masked bool, authorID, quoteOf int) (Chirp, error) {
+	now := time.Now().UTC()
+	chirp := Chirp{
+		Body:      body,
+		Masked:    masked,
+		AuthorID:  authorID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	err := db.updateThen(func(tx *sql.Tx) ([]Change, error) {
+		err := tx.QueryRow(
+			`SELECT COALESCE(rechirp_of, id) FROM chirps WHERE id = ? AND `+sqlVisible, quoteOf,
+		).Scan(&chirp.QuoteOf)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		id, err := insertChirp(tx, chirp, false)
+		if err != nil {
+			return nil, err
+		}
+		chirp.ID = id
+		return []Change{ChirpCreated{Chirp: chirp}}, nil
+	}, func() {
+		db.indexChirp(chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirpStats(ids []int) (map[int]ChirpStats, error) {
+	stats := make(map[int]ChirpStats, len(ids))
+	for _, id := range ids {
+		stats[id] = ChirpStats{}
+	}
+	err := inBatches(ids, func(in string, args []any) error {
+		rows, err := db.conn.Query(
+			`SELECT COALESCE(rechirp_of, quote_of), rechirp_of IS NOT NULL, COUNT(*) FROM chirps
+			WHERE deleted_at IS NULL AND (rechirp_of IN `+in+` OR quote_of IN `+in+`)
+			GROUP BY 1, 2`,
+			append(args, args...)...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, count int
+			var rechirps bool
+			err := rows.Scan(&id, &rechirps, &count)
+			if err != nil {
+				return err
+			}
+			s := stats[id]
+			if rechirps {
+				s.Rechirps = count
+			} else {
+				s.Quotes = count
+			}
+			stats[id] = s
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = inBatches(ids, func(in string, args []any) error {
+		rows, err := db.conn.Query(`SELECT chirp_id, COUNT(*) FROM likes WHERE chirp_id IN `+in+` GROUP BY chirp_id`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, count int
+			err := rows.Scan(&id, &count)
+			if err != nil {
+				return err
+			}
+			s := stats[id]
+			s.Likes = count
+			stats[id] = s
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (db *SQLiteDB) GetChirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := make(map[int]Chirp, len(ids))
+	err := inBatches(ids, func(in string, args []any) error {
+		found, err := queryChirps(db.conn, `SELECT `+chirpColumns+` FROM chirps WHERE id IN `+in, args...)
+		if err != nil {
+			return err
+		}
+		for _, chirp := range found {
+			chirps[chirp.ID] = chirp
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
+
+// inBatches calls fn for each run of up to sqlBatchSize ids, with a
+// parenthesised list of placeholders for them and the matching arguments.
+func inBatches(ids []int, fn func(in string, args []any) error) error {
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), sqlBatchSize)]
+		ids = ids[len(batch):]
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		in := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ") + ")"
+		if err := fn(in, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
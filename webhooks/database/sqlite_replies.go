@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+func (db *SQLiteDB) GetReplies(id int, by SortKey, order SortOrder) ([]Chirp, error) {
+	var exists bool
+	err := db.conn.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ?)`, id).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotExist
+	}
+	return queryChirps(db.conn,
+		`SELECT `+chirpColumns+` FROM chirps WHERE in_reply_to = ? AND deleted_at IS NULL ORDER BY `+sqlOrderBy(by, order),
+		id,
+	)
+}
+
+// sqlThread lists the chirps in a thread with their depth below its root,
+// given the root's ID and the depth to stop at. A chirp in a loop is listed
+// more than once.
+const sqlThread = `
+WITH RECURSIVE thread(id, depth) AS (
+	SELECT ?, 0
+	UNION
+	SELECT chirps.id, thread.depth + 1 FROM chirps JOIN thread ON chirps.in_reply_to = thread.id
+	WHERE thread.depth < ?
+)`
+
+func (db *SQLiteDB) GetConversation(id, maxDepth int) (Conversation, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	// Walk up to the root: the one ancestor whose parent isn't there. UNION
+	// rather than UNION ALL stops at loops in hand-edited data, which have
+	// no root, so the chirp itself is used instead.
+	var rootID int
+	err = tx.QueryRow(`
+WITH RECURSIVE ancestors(id, in_reply_to) AS (
+	SELECT id, in_reply_to FROM chirps WHERE id = ?
+	UNION
+	SELECT chirps.id, chirps.in_reply_to FROM chirps JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT id FROM ancestors
+WHERE in_reply_to IS NULL OR in_reply_to NOT IN (SELECT id FROM chirps)`, id,
+	).Scan(&rootID)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ?)`, id).Scan(&exists)
+		if err != nil {
+			return Conversation{}, err
+		}
+		if !exists {
+			return Conversation{}, ErrNotExist
+		}
+		rootID = id
+	} else if err != nil {
+		return Conversation{}, err
+	}
+
+	conversation := Conversation{MoreReplies: map[int]int{}}
+	conversation.Chirps, err = queryChirps(tx, sqlThread+`
+SELECT `+chirpColumns+` FROM chirps WHERE id IN (SELECT id FROM thread)
+ORDER BY id = ? DESC, id`, rootID, maxDepth, rootID,
+	)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	rows, err := tx.Query(sqlThread+`
+SELECT in_reply_to, COUNT(*) FROM chirps AS replies
+WHERE in_reply_to IN (SELECT id FROM thread WHERE depth = ?)
+	AND (deleted_at IS NULL OR EXISTS (SELECT 1 FROM chirps WHERE in_reply_to = replies.id))
+GROUP BY in_reply_to`, rootID, maxDepth, maxDepth,
+	)
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var parentID, n int
+		if err := rows.Scan(&parentID, &n); err != nil {
+			return Conversation{}, err
+		}
+		conversation.MoreReplies[parentID] = n
+	}
+	return conversation, rows.Err()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+func (db *SQLiteDB) SearchChirps(query string, limit int) ([]SearchResult, error) {
+	db.searchMu.RLock()
+	results, err := db.search.search(query)
+	db.searchMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// A chirp can be deleted between searching and fetching it, so results
+	// are filled in until there are enough.
+	found := make([]SearchResult, 0, min(len(results), limit))
+	for _, result := range results {
+		if len(found) == limit {
+			break
+		}
+		chirp, err := db.GetChirp(result.Chirp.ID)
+		if errors.Is(err, ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Chirp = chirp
+		found = append(found, result)
+	}
+	return found, nil
+}
+
+// RebuildSearchIndex indexes every chirp from scratch, for both search and
+// hashtags. It is also how the indexes catch up after changes made outside
+// this SQLiteDB, such as by another process using the same file.
+func (db *SQLiteDB) RebuildSearchIndex() error {
+	// Holding writeMu keeps a chirp changed while the table is read from
+	// being indexed and then overwritten by the stale rebuild.
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	chirps, err := queryChirps(db.conn, `SELECT `+chirpColumns+` FROM chirps WHERE deleted_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	search, hashtags := newSearchIndex(), newHashtagIndex()
+	for _, chirp := range chirps {
+		search.add(chirp)
+		hashtags.uses = append(hashtags.uses, hashtags.put(chirp)...)
+	}
+	hashtags.sortUses()
+
+	db.searchMu.Lock()
+	defer db.searchMu.Unlock()
+	db.search, db.hashtags = search, hashtags
+	return nil
+}
+
+// indexChirp and unindexChirp keep the search and hashtag indexes up to
+// date with changes to single chirps. They run as updateThen hooks, once
+// the change has been committed.
+func (db *SQLiteDB) indexChirp(chirp Chirp) {
+	db.searchMu.Lock()
+	defer db.searchMu.Unlock()
+	db.search.add(chirp)
+	db.hashtags.add(chirp)
+}
+
+func (db *SQLiteDB) unindexChirp(id int) {
+	db.searchMu.Lock()
+	defer db.searchMu.Unlock()
+	db.search.remove(id)
+	db.hashtags.remove(id)
+}
+
+// commitAndReindex commits a transaction that changed chirps in bulk and
+// rebuilds the search index to match.
+func (db *SQLiteDB) commitAndReindex(tx *sql.Tx) error {
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+	return db.RebuildSearchIndex()
+}
@@ -0,0 +1,328 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (db *SQLiteDB) Snapshot() (DBStructure, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return DBStructure{}, err
+	}
+	defer tx.Rollback()
+
+	data := newDBStructure()
+
+	users, err := queryUsers(tx, `SELECT `+userColumns+` FROM users`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for _, user := range users {
+		data.Users[user.ID] = user
+	}
+
+	chirps, err := queryChirps(tx, `SELECT `+chirpColumns+` FROM chirps`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for _, chirp := range chirps {
+		data.Chirps[chirp.ID] = chirp
+	}
+
+	rows, err := tx.Query(`SELECT chirp_id, body, created_at FROM chirp_revisions ORDER BY chirp_id, revision`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		var chirpID int
+		revision := ChirpRevision{}
+		err := rows.Scan(&chirpID, &revision.Body, &revision.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		revision.CreatedAt = revision.CreatedAt.UTC()
+		if chirp, ok := data.Chirps[chirpID]; ok {
+			chirp.History = append(chirp.History, revision)
+			data.Chirps[chirpID] = chirp
+		}
+	}
+	rows.Close()
+
+	likes, err := queryLikes(tx, `SELECT `+likeColumns+` FROM likes`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for _, like := range likes {
+		data.Likes[like.ID] = like
+	}
+
+	rows, err = tx.Query(`SELECT token, user_id, expires_at FROM refresh_tokens`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		token := RefreshToken{}
+		err := rows.Scan(&token.Token, &token.UserID, &token.ExpiresAt)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		data.RefreshTokens[token.Token] = token
+	}
+	rows.Close()
+
+	data.Sequences.Users, err = sqliteSequence(tx, "users")
+	if err != nil {
+		return DBStructure{}, err
+	}
+	data.Sequences.Chirps, err = sqliteSequence(tx, "chirps")
+	if err != nil {
+		return DBStructure{}, err
+	}
+	data.Sequences.Likes, err = sqliteSequence(tx, "likes")
+	if err != nil {
+		return DBStructure{}, err
+	}
+
+	return data, nil
+}
+
+func (db *SQLiteDB) Restore(data DBStructure) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+DELETE FROM refresh_tokens;
+DELETE FROM likes;
+DELETE FROM chirp_revisions;
+DELETE FROM chirps;
+DELETE FROM users;
+`)
+	if err != nil {
+		return err
+	}
+	err = insertAll(tx, data)
+	if err != nil {
+		return err
+	}
+	return db.commitAndReindex(tx)
+}
+
+// ImportJSON copies the contents of a database.json file into an empty
+// SQLite database, keeping the original IDs. keys is only needed if the
+// file is encrypted.
+func (db *SQLiteDB) ImportJSON(path string, keys *Keyring) error {
+	data, _, err := readDBFile(path, keys)
+	if err != nil {
+		return err
+	}
+	_, err = applyMigrations(&data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow(`SELECT (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM chirps)`).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("refusing to import into a non-empty database")
+	}
+
+	err = insertAll(tx, data)
+	if err != nil {
+		return err
+	}
+	return db.commitAndReindex(tx)
+}
+
+func insertAll(tx *sql.Tx, data DBStructure) error {
+	for _, user := range data.Users {
+		_, err := insertUser(tx, user, true)
+		if err != nil {
+			return fmt.Errorf("user %d: %w", user.ID, err)
+		}
+	}
+	for _, chirp := range data.Chirps {
+		_, err := insertChirp(tx, chirp, true)
+		if err != nil {
+			return fmt.Errorf("chirp %d: %w", chirp.ID, err)
+		}
+	}
+	for _, like := range data.Likes {
+		_, err := insertLike(tx, like, true)
+		if err != nil {
+			return fmt.Errorf("like %d: %w", like.ID, err)
+		}
+	}
+	for _, token := range data.RefreshTokens {
+		_, err := tx.Exec(
+			`INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES (?, ?, ?)`,
+			token.Token, token.UserID, token.ExpiresAt.UTC(),
+		)
+		if err != nil {
+			return fmt.Errorf("refresh token for user %d: %w", token.UserID, err)
+		}
+	}
+
+	err := setSQLiteSequence(tx, "users", data.Sequences.Users)
+	if err != nil {
+		return err
+	}
+	err = setSQLiteSequence(tx, "chirps", data.Sequences.Chirps)
+	if err != nil {
+		return err
+	}
+	return setSQLiteSequence(tx, "likes", data.Sequences.Likes)
+}
+
+// sqliteSequence returns the last ID handed out for an AUTOINCREMENT table.
+func sqliteSequence(tx *sql.Tx, table string) (int, error) {
+	var seq int
+	err := tx.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name = ?`, table).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// setSQLiteSequence moves an AUTOINCREMENT counter forward to at least seq.
+func setSQLiteSequence(tx *sql.Tx, table string, seq int) error {
+	current, err := sqliteSequence(tx, table)
+	if err != nil || current >= seq {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM sqlite_sequence WHERE name = ?`, table)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)`, table, seq)
+	return err
+}
+
+func (db *SQLiteDB) Import(data DBStructure, remapIDs bool) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userIDs := map[int]int{}
+	for _, id := range sortedKeys(data.Users) {
+		newID, err := insertUser(tx, data.Users[id], !remapIDs)
+		if isUniqueViolation(err) || isPrimaryKeyViolation(err) {
+			return fmt.Errorf("user %d: %w", id, ErrAlreadyExists)
+		}
+		if err != nil {
+			return fmt.Errorf("user %d: %w", id, err)
+		}
+		userIDs[id] = newID
+	}
+	resolveUser := func(id int) (int, error) {
+		if newID, ok := userIDs[id]; ok {
+			return newID, nil
+		}
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, id).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, fmt.Errorf("user %d: %w", id, ErrMissingReference)
+		}
+		return id, nil
+	}
+
+	// Replies come after the chirps they reply to, so in ID order those
+	// have already been given their new IDs.
+	chirpIDs := map[int]int{}
+	resolveChirp := func(id int) (int, error) {
+		if newID, ok := chirpIDs[id]; ok {
+			return newID, nil
+		}
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ?)`, id).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, fmt.Errorf("chirp %d: %w", id, ErrMissingReference)
+		}
+		return id, nil
+	}
+
+	for _, id := range sortedKeys(data.Chirps) {
+		chirp := data.Chirps[id]
+		authorID, err := resolveUser(chirp.AuthorID)
+		if err != nil {
+			return fmt.Errorf("chirp %d: %w", id, err)
+		}
+		chirp.AuthorID = authorID
+		for _, ref := range []*int{&chirp.InReplyTo, &chirp.RechirpOf, &chirp.QuoteOf} {
+			if *ref == 0 {
+				continue
+			}
+			*ref, err = resolveChirp(*ref)
+			if err != nil {
+				return fmt.Errorf("chirp %d: %w", id, err)
+			}
+		}
+		newID, err := insertChirp(tx, chirp, !remapIDs)
+		if isPrimaryKeyViolation(err) {
+			return fmt.Errorf("chirp %d: %w", id, ErrAlreadyExists)
+		}
+		if err != nil {
+			return fmt.Errorf("chirp %d: %w", id, err)
+		}
+		chirpIDs[id] = newID
+	}
+
+	for _, id := range sortedKeys(data.Likes) {
+		like := data.Likes[id]
+		var err error
+		like.UserID, err = resolveUser(like.UserID)
+		if err != nil {
+			return fmt.Errorf("like %d: %w", id, err)
+		}
+		like.ChirpID, err = resolveChirp(like.ChirpID)
+		if err != nil {
+			return fmt.Errorf("like %d: %w", id, err)
+		}
+		_, err = insertLike(tx, like, !remapIDs)
+		if isUniqueViolation(err) || isPrimaryKeyViolation(err) {
+			return fmt.Errorf("like %d: %w", id, ErrAlreadyExists)
+		}
+		if err != nil {
+			return fmt.Errorf("like %d: %w", id, err)
+		}
+	}
+
+	for _, token := range data.RefreshTokens {
+		userID, err := resolveUser(token.UserID)
+		if err != nil {
+			return fmt.Errorf("refresh token: %w", err)
+		}
+		_, err = tx.Exec(
+			`INSERT OR REPLACE INTO refresh_tokens (token, user_id, expires_at) VALUES (?, ?, ?)`,
+			token.Token, userID, token.ExpiresAt.UTC(),
+		)
+		if err != nil {
+			return fmt.Errorf("refresh token for user %d: %w", token.UserID, err)
+		}
+	}
+
+	return db.commitAndReindex(tx)
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// userColumns is the column list scanUser expects.
+const userColumns = `id, email, hashed_password, is_chirpy_red, version`
+
+func scanUser(row interface{ Scan(dest ...any) error }) (User, error) {
+	user := User{}
+	err := row.Scan(&user.ID, &user.Email, &user.HashedPassword, &user.IsChirpyRed, &user.Version)
+	return user, err
+}
+
+func queryUsers(q sqlQuerier, query string, args ...any) ([]User, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// insertUser stores user and returns its ID. Unless keepID is set, the ID
+// in user is ignored and a new one is assigned.
+func insertUser(e sqlExecer, user User, keepID bool) (int, error) {
+	var id any
+	if keepID {
+		id = user.ID
+	}
+	version := user.Version
+	if version == 0 {
+		version = 1
+	}
+	res, err := e.Exec(
+		`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		id, user.Email, user.HashedPassword, user.IsChirpyRed, version,
+	)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := res.LastInsertId()
+	return int(newID), err
+}
+
+func (db *SQLiteDB) CreateUser(email, hashedPassword string) (User, error) {
+	user := User{
+		Email:          email,
+		HashedPassword: hashedPassword,
+		Version:        1,
+	}
+	id, err := insertUser(db.conn, user, false)
+	if isUniqueViolation(err) {
+		return User{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = id
+	return user, nil
+}
+
+func (db *SQLiteDB) GetUser(id int) (User, error) {
+	return db.getUser(`SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+}
+
+func (db *SQLiteDB) GetUserByEmail(email string) (User, error) {
+	return db.getUser(`SELECT `+userColumns+` FROM users WHERE email = ?`, email)
+}
+
+func (db *SQLiteDB) getUser(query string, arg any) (User, error) {
+	user, err := scanUser(db.conn.QueryRow(query, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotExist
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) UpdateUser(id int, email, hashedPassword string, ifVersion int) (User, error) {
+	var user User
+	err := db.update(func(tx *sql.Tx) ([]Change, error) {
+		var err error
+		user, err = scanUser(tx.QueryRow(
+			`UPDATE users SET email = ?, hashed_password = ?, version = version + 1
+			WHERE id = ? AND (? = 0 OR version = ?)
+			RETURNING `+userColumns,
+			email, hashedPassword, id, ifVersion, ifVersion,
+		))
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			if _, err := db.GetUser(id); err != nil {
+				return nil, err
+			}
+			return nil, ErrVersionConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []Change{UserUpdated{UserID: id, Email: email}}, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLiteDB) UpgradeChirpyRed(id int) (User, error) {
+	var user User
+	err := db.update(func(tx *sql.Tx) ([]Change, error) {
+		var err error
+		user, err = scanUser(tx.QueryRow(
+			`UPDATE users SET is_chirpy_red = 1, version = version + 1 WHERE id = ? RETURNING `+userColumns, id,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []Change{UserUpgraded{UserID: id}}, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import "time"
+
+// Store is the set of operations the API needs from a storage backend.
+// DB persists to a JSON file (or nowhere, see NewMemDB) and SQLiteDB to a
+// SQLite database.
+type Store interface {
+	ResetDB() error
+	Close() error
+
+	// Snapshot returns a consistent copy of everything in the store.
+	// Restore replaces everything in the store with data.
+	Snapshot() (DBStructure, error)
+	Restore(data DBStructure) error
+	// Import adds the records in data to the store, either keeping their
+	// IDs or, with remapIDs, giving them fresh ones.
+	Import(data DBStructure, remapIDs bool) error
+	// Fsck checks the integrity of the store and, with repair, fixes what
+	// it safely can.
+	Fsck(repair bool) (FsckReport, error)
+
+	// CreateChirp posts a chirp, replying to the chirp with ID inReplyTo
+	// unless it is zero. It fails with ErrMissingReference if that chirp
+	// doesn't exist.
+	CreateChirp(body string, masked bool, authorID, inReplyTo int) (Chirp, error)
+	GetChirps(by SortKey, order SortOrder) ([]Chirp, error)
+	GetChirpsByAuthor(authorID int, by SortKey, order SortOrder) ([]Chirp, error)
+	GetChirp(id int) (Chirp, error)
+	// GetChirpHistory returns every body a chirp has had, oldest first and
+	// ending with the current one.
+	GetChirpHistory(id int) ([]ChirpRevision, error)
+	UpdateChirp(id int, body string, masked bool, ifVersion int) (Chirp, error)
+	// DeleteChirp leaves a tombstone that can be brought back with
+	// UndeleteChirp until PurgeDeletedChirps removes it for good.
+	//
+	// UpdateChirp, DeleteChirp and UpdateUser take the version the caller
+	// last saw, or zero for any, and fail with ErrVersionConflict if the
+	// record has moved on since.
+	DeleteChirp(id, deletedBy, ifVersion int) error
+	GetDeletedChirp(id int) (Chirp, error)
+	UndeleteChirp(id int) (Chirp, error)
+	PurgeDeletedChirps(deletedBefore time.Time) (int, error)
+	// GetReplies returns the chirps replying to a chirp, which may itself
+	// be deleted. GetConversation returns the root of the thread a chirp
+	// belongs to followed by the replies up to maxDepth levels below it,
+	// including deleted ones.
+	GetReplies(id int, by SortKey, order SortOrder) ([]Chirp, error)
+	GetConversation(id, maxDepth int) (Conversation, error)
+
+	// CreateRechirp shares a chirp, failing with ErrAlreadyExists if the
+	// user already has, and DeleteRechirp undoes that. CreateQuote posts a
+	// chirp quoting another. Rechirps and quotes of a rechirp refer to the
+	// chirp it shares.
+	CreateRechirp(chirpID, userID int) (Chirp, error)
+	DeleteRechirp(chirpID, userID int) (Chirp, error)
+	CreateQuote(body string, masked bool, authorID, quoteOf int) (Chirp, error)
+	// GetChirpStats counts the live rechirps and quotes of each chirp in
+	// ids, and its likes. GetChirpsByID returns those of ids that exist,
+	// deleted or not.
+	GetChirpStats(ids []int) (map[int]ChirpStats, error)
+	GetChirpsByID(ids []int) (map[int]Chirp, error)
+
+	// LikeChirp and UnlikeChirp add and take back a user's like of a chirp,
+	// and do nothing if it is already liked or not. Likes of a rechirp go
+	// to the chirp it shares. GetChirpLikes and GetUserLikes page through
+	// the likes of a chirp and by a user, newest first. UnlikeChirp returns
+	// the ID of the chirp the like was taken back from.
+	LikeChirp(chirpID, userID int) (Like, error)
+	UnlikeChirp(chirpID, userID int) (int, error)
+	GetChirpLikes(chirpID, before, limit int) (LikePage, error)
+	GetUserLikes(userID, before, limit int) (LikePage, error)
+	// SearchChirps returns up to limit chirps matching a full-text query,
+	// best match first. It fails with ErrEmptyQuery if the query has no
+	// words. RebuildSearchIndex indexes every chirp from scratch.
+	SearchChirps(query string, limit int) ([]SearchResult, error)
+	RebuildSearchIndex() error
+	// GetChirpsByHashtag returns the visible chirps using a tag, with or
+	// without its #, in any case. It fails with ErrInvalidHashtag if the
+	// tag can't be a hashtag. GetTrendingHashtags ranks the tags used in
+	// the last window by how often and how recently they were used.
+	GetChirpsByHashtag(tag string, by SortKey, order SortOrder) ([]Chirp, error)
+	GetTrendingHashtags(window time.Duration, limit int) ([]TrendingHashtag, error)
+
+	CreateUser(email, hashedPassword string) (User, error)
+	GetUser(id int) (User, error)
+	GetUserByEmail(email string) (User, error)
+	UpdateUser(id int, email, hashedPassword string, ifVersion int) (User, error)
+	UpgradeChirpyRed(id int) (User, error)
+
+	SaveRefreshToken(userID int, token string) error
+	// RevokeRefreshToken deletes the token straight away, so only expired
+	// tokens are left for PurgeExpiredRefreshTokens to clean up.
+	RevokeRefreshToken(token string) error
+	PurgeExpiredRefreshTokens(expiredBefore time.Time) (int, error)
+	UserForRefreshToken(token string) (User, error)
+
+	// Subscribe delivers the change events committed after the one
+	// numbered after, starting with those the store still retains, and
+	// then new ones as they are committed.
+	Subscribe(after uint64) (*Subscription, error)
+	LastEventSeq() (uint64, error)
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*SQLiteDB)(nil)
+)
@@ -0,0 +1,113 @@
+package database
+
+import "errors"
+
+// ErrReadOnly is returned for writes in a View or to a DB opened with
+// Options.ReadOnly.
+var ErrReadOnly = errors.New("read-only transaction")
+
+// Tx gives access to the database for the duration of a call to Update or
+// View. It must not be used after the callback returns.
+type Tx struct {
+	data     *DBStructure
+	writable bool
+	// undo reverts the changes made so far, newest last.
+	undo []func()
+	// mutations records the changes made so far, oldest first.
+	mutations []mutation
+	// events are published to subscribers once the transaction commits.
+	events []Event
+}
+
+// Update runs fn with exclusive access to the database. If fn returns nil,
+// its changes are kept and persisted (written to the file or the
+// write-ahead log, or queued for the write-behind flusher) before Update
+// returns; otherwise they are rolled back.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx := &Tx{data: db.data, writable: true}
+	err := fn(tx)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	if len(tx.undo) == 0 {
+		return nil
+	}
+
+	entry := LogEntry{
+		Seq:       db.data.LogSeq + 1,
+		Mutations: tx.mutations,
+	}
+	db.data.LogSeq = entry.Seq
+	switch {
+	case db.inMemory():
+	case db.wal != nil:
+		err = db.appendLog(entry)
+	case db.flushInterval > 0:
+		db.dirty.Store(true)
+	default:
+		err = db.writeDB(*db.data)
+	}
+	if err != nil {
+		tx.rollback()
+		db.data.LogSeq = entry.Seq - 1
+		return err
+	}
+	db.recordLog(entry)
+	db.events.publish(tx.events)
+	return nil
+}
+
+// View runs fn with a consistent, read-only view of the database.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return fn(&Tx{data: db.data})
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+	tx.mutations = nil
+	tx.events = nil
+}
+
+func (tx *Tx) checkWritable() error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// put and remove change a collection and remember how to undo it.
+func put[K comparable, V any](tx *Tx, m map[K]V, key K, val V) {
+	old, existed := m[key]
+	tx.undo = append(tx.undo, func() {
+		if existed {
+			m[key] = old
+		} else {
+			delete(m, key)
+		}
+	})
+	m[key] = val
+}
+
+func remove[K comparable, V any](tx *Tx, m map[K]V, key K) {
+	old, existed := m[key]
+	if !existed {
+		return
+	}
+	tx.undo = append(tx.undo, func() {
+		m[key] = old
+	})
+	delete(m, key)
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentCreates fires many CreateChirp calls at once and checks
+// that every one of them survives reopening the store with a unique ID.
+func TestConcurrentCreates(t *testing.T) {
+	const n = 300
+
+	stores := []struct {
+		name string
+		open func(path string) (Store, error)
+	}{
+		{"json", func(path string) (Store, error) {
+			return NewDB(path)
+		}},
+		{"json flush interval", func(path string) (Store, error) {
+			return NewDBWithOptions(path, Options{FlushInterval: 10 * time.Millisecond})
+		}},
+		{"json wal", func(path string) (Store, error) {
+			return NewDBWithOptions(path, Options{WAL: true, CompactThreshold: 4096})
+		}},
+		{"sqlite", func(path string) (Store, error) {
+			return NewSQLiteDB(path)
+		}},
+	}
+
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chirpy.db")
+			db, err := s.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, err := db.CreateChirp(fmt.Sprintf("chirp %d", i), false, 1, 0)
+					if err != nil {
+						errs <- err
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("CreateChirp: %v", err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = s.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			chirps, err := db.GetChirps(SortByID, SortAsc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chirps) != n {
+				t.Fatalf("got %d chirps after reopening, want %d", len(chirps), n)
+			}
+			ids := map[int]bool{}
+			bodies := map[string]bool{}
+			for _, chirp := range chirps {
+				if ids[chirp.ID] {
+					t.Errorf("chirp ID %d is used more than once", chirp.ID)
+				}
+				ids[chirp.ID] = true
+				bodies[chirp.Body] = true
+			}
+			if len(bodies) != n {
+				t.Errorf("got %d distinct bodies, want %d", len(bodies), n)
+			}
+		})
+	}
+}
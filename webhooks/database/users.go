@@ -7,29 +7,91 @@ type User struct {
 	Email          string `json:"email"`
 	HashedPassword string `json:"hashed_password"`
 	IsChirpyRed    bool   `json:"is_chirpy_red"`
+	// Version starts at 1 and goes up by one on every change.
+	Version int `json:"version"`
 }
 
 var ErrAlreadyExists = errors.New("already exists")
 
+// ErrVersionConflict is returned when a change is made conditional on a
+// record's version and the record has changed since.
+var ErrVersionConflict = errors.New("record has been modified")
+
 func (db *DB) CreateUser(email, hashedPassword string) (User, error) {
-	if _, err := db.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
-		return User{}, ErrAlreadyExists
-	}
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.CreateUser(email, hashedPassword)
+		return err
+	})
+	return user, err
+}
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
+func (db *DB) GetUser(id int) (User, error) {
+	var user User
+	err := db.View(func(tx *Tx) error {
+		var err error
+		user, err = tx.GetUser(id)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) GetUserByEmail(email string) (User, error) {
+	var user User
+	err := db.View(func(tx *Tx) error {
+		var err error
+		user, err = tx.GetUserByEmail(email)
+		return err
+	})
+	return user, err
+}
+
+// UpdateUser changes a user's email and password. If ifVersion isn't zero,
+// the update only happens if the user is still at that version.
+func (db *DB) UpdateUser(
+	id int,
+	email,
+	hashedPassword string,
+	ifVersion int,
+) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.UpdateUser(id, email, hashedPassword, ifVersion)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) UpgradeChirpyRed(
+	id int,
+) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.UpgradeChirpyRed(id)
+		return err
+	})
+	return user, err
+}
+
+func (tx *Tx) CreateUser(email, hashedPassword string) (User, error) {
+	if err := tx.checkWritable(); err != nil {
 		return User{}, err
 	}
+	if _, err := tx.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
+		return User{}, ErrAlreadyExists
+	}
 
-	id := len(dbStructure.Users) + 1
+	id := tx.nextUserID()
 	user := User{
 		ID:             id,
 		Email:          email,
 		HashedPassword: hashedPassword,
+		Version:        1,
 	}
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
+	err := tx.putUser(user)
 	if err != nil {
 		return User{}, err
 	}
@@ -37,82 +99,64 @@ func (db *DB) CreateUser(email, hashedPassword string) (User, error) {
 	return user, nil
 }
 
-func (db *DB) GetUser(id int) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	user, ok := dbStructure.Users[id]
+func (tx *Tx) GetUser(id int) (User, error) {
+	user, ok := tx.data.Users[id]
 	if !ok {
 		return User{}, ErrNotExist
 	}
-
 	return user, nil
 }
 
-func (db *DB) GetUserByEmail(email string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return user, nil
-		}
+func (tx *Tx) GetUserByEmail(email string) (User, error) {
+	id, ok := tx.data.idx.userByEmail[email]
+	if !ok {
+		return User{}, ErrNotExist
 	}
-
-	return User{}, ErrNotExist
+	return tx.GetUser(id)
 }
 
-func (db *DB) UpdateUser(
-	id int,
-	email,
-	hashedPassword string,
-) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
+func (tx *Tx) UpdateUser(id int, email, hashedPassword string, ifVersion int) (User, error) {
+	if err := tx.checkWritable(); err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
+	user, ok := tx.data.Users[id]
 	if !ok {
 		return User{}, ErrNotExist
 	}
+	if ifVersion != 0 && user.Version != ifVersion {
+		return User{}, ErrVersionConflict
+	}
 
 	user.Email = email
 	user.HashedPassword = hashedPassword
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
+	user.Version++
+	err := tx.putUser(user)
 	if err != nil {
 		return User{}, err
 	}
+	tx.emit(UserUpdated{UserID: id, Email: email})
 
 	return user, nil
 }
 
-func (db *DB) UpgradeChirpyRed(
-	id int,
-) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
+func (tx *Tx) UpgradeChirpyRed(id int) (User, error) {
+	if err := tx.checkWritable(); err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
+	user, ok := tx.data.Users[id]
 	if !ok {
 		return User{}, ErrNotExist
 	}
 
 	user.IsChirpyRed = true
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
+	user.Version++
+	err := tx.putUser(user)
 	if err != nil {
 		return User{}, err
 	}
+	tx.emit(UserUpgraded{UserID: id})
 
 	return user, nil
-}
\ No newline at end of file
+}
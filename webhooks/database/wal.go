@@ -0,0 +1,192 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// defaultCompactThreshold is the write-ahead log size above which it is
+// folded into a new snapshot.
+const defaultCompactThreshold = 4 << 20
+
+// LogEntry is one committed transaction. Entries are stored one per line in
+// the write-ahead log and sent to followers by replication.
+type LogEntry struct {
+	Seq       uint64     `json:"seq"`
+	Mutations []mutation `json:"mutations"`
+}
+
+// errLogGap means the write-ahead log doesn't follow on from the snapshot
+// it was replayed onto.
+var errLogGap = errors.New("write-ahead log doesn't follow on from the snapshot")
+
+// walFile is the open write-ahead log of a DB running in WAL mode.
+type walFile struct {
+	f    *os.File
+	size int64
+}
+
+func (db *DB) walPath() string {
+	return db.path + ".wal"
+}
+
+func (db *DB) openLog() error {
+	f, err := os.OpenFile(db.walPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	db.wal = &walFile{f: f, size: info.Size()}
+	return nil
+}
+
+// appendLog makes a transaction durable. Callers must hold db.mu for
+// writing.
+func (db *DB) appendLog(entry LogEntry) error {
+	dat, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	dat, err = db.keys.seal(dat)
+	if err != nil {
+		return err
+	}
+	dat = append(dat, '\n')
+
+	_, err = db.wal.f.Write(dat)
+	if err == nil {
+		err = db.wal.f.Sync()
+	}
+	if err != nil {
+		// Don't leave a partial record for later entries to be
+		// appended after.
+		db.wal.f.Truncate(db.wal.size)
+		return err
+	}
+	db.wal.size += int64(len(dat))
+
+	if db.wal.size > db.compactThreshold {
+		select {
+		case db.compact <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (db *DB) compactLoop() {
+	defer close(db.stopped)
+
+	for {
+		select {
+		case <-db.compact:
+			err := db.compactLog()
+			if err != nil {
+				log.Printf("Couldn't compact %s: %s", db.walPath(), err)
+			}
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// compactLog writes a new snapshot and empties the write-ahead log.
+func (db *DB) compactLog() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.wal.size == 0 {
+		return nil
+	}
+	size := db.wal.size
+	err := db.writeDB(*db.data)
+	if err != nil {
+		return err
+	}
+	err = db.wal.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	db.wal.size = 0
+	log.Printf("Compacted %d bytes of %s into %s", size, db.walPath(), db.path)
+	return nil
+}
+
+// replayLog applies the entries of the write-ahead log that are newer than
+// the snapshot in dbStructure. A torn last entry, left behind by a crash
+// halfway through a write, is dropped and cut off the file. It returns the
+// number of entries applied.
+func (db *DB) replayLog(dbStructure *DBStructure) (int, error) {
+	flag := os.O_RDWR
+	if db.readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(db.walPath(), flag, 0600)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	tx := &Tx{data: dbStructure, writable: true}
+	applied := 0
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A read-only DB may be looking at a record that is still
+			// being written, so it leaves the file alone.
+			if len(bytes.TrimSpace(line)) > 0 && !db.readOnly {
+				log.Printf("Dropping torn record at offset %d of %s", offset, db.walPath())
+				if err := f.Truncate(offset); err != nil {
+					return applied, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		entry := LogEntry{}
+		dat, _, err := db.keys.open(bytes.TrimSpace(line))
+		if err == nil {
+			err = json.Unmarshal(dat, &entry)
+		}
+		if err != nil {
+			return applied, fmt.Errorf("%s: bad record at offset %d: %w", db.walPath(), offset, err)
+		}
+		offset += int64(len(line))
+
+		if entry.Seq <= dbStructure.LogSeq {
+			continue
+		}
+		if db.readOnly && entry.Seq != dbStructure.LogSeq+1 {
+			// The writer compacted the log between our reads of the
+			// file and the log.
+			return applied, errLogGap
+		}
+		for _, m := range entry.Mutations {
+			err := tx.applyMutation(m)
+			if err != nil {
+				return applied, fmt.Errorf("%s: record %d: %w", db.walPath(), entry.Seq, err)
+			}
+		}
+		dbStructure.LogSeq = entry.Seq
+		applied++
+	}
+	return applied, nil
+}